@@ -0,0 +1,234 @@
+package chanrpc
+
+import (
+	"errors"
+	"time"
+)
+
+// Policy decide what to do with a new call when the lane of server it is sent to is full.
+type Policy int
+
+const (
+	PolicyTimeout    Policy = iota // sync call blocks, async call waits for the timeout of client, the default policy.
+	PolicyBlock                    // block until the call is accepted, or the context of call is done.
+	PolicyReject                   // reject the new call at once.
+	PolicyDropOldest               // drop the oldest call in lane, which is returned with error, and accept the new one.
+	PolicyShed                     // shed calls by priority, see Priority.
+)
+
+// Priority of call, which decides the lane of call. Under PolicyShed, system calls block like PolicyBlock, normal calls
+// are rejected when the lane is full, and bulk calls are rejected once the calls waiting in all lanes reach the high
+// watermark, or half of a lane if no watermark is set.
+type Priority int
+
+const (
+	PrioritySystem Priority = iota // calls critical to admin and shutdown.
+	PriorityNormal                 // the default priority.
+	PriorityBulk                   // calls of low value, such as flood of client message.
+)
+
+// Counter counts calls of one id which are not executed for backpressure.
+type Counter struct {
+	Rejected int // calls rejected when sent.
+	Dropped  int // calls dropped from lane by PolicyDropOldest.
+}
+
+// ErrBusy is returned when the call is rejected for the server is busy.
+var ErrBusy = errors.New("server is too busy.")
+
+// errDropped is returned to the call dropped by PolicyDropOldest.
+var errDropped = errors.New("call dropped, server is too busy.")
+
+// SetPolicy set the backpressure policy of server.
+func (s *Server) SetPolicy(p Policy) {
+	s.mutex.Lock()
+	s.policy = p
+	s.mutex.Unlock()
+}
+
+// SetPriority set the priority of id, ids not set are PriorityNormal. It can be overridden by WithPriority.
+func (s *Server) SetPriority(id interface{}, p Priority) {
+	s.mutex.Lock()
+	s.priorities[id] = p
+	s.mutex.Unlock()
+}
+
+// SetHighWatermark make f called when the number of calls waiting in all lanes reaches n. f is called once each time the
+// lanes grow over n, in the goroutine of the caller, so it must be goroutine safe.
+func (s *Server) SetHighWatermark(n int, f func(depth int)) {
+	s.mutex.Lock()
+	s.watermark = n
+	s.onWatermark = f
+	s.overWatermark = false
+	s.mutex.Unlock()
+}
+
+// Counter return the backpressure counter of id, it is goroutine safe.
+func (s *Server) Counter(id interface{}) Counter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.counters[id]; ok {
+		return *c
+	}
+	return Counter{}
+}
+
+// Counters return the backpressure counters of all ids having calls not executed, it is goroutine safe.
+func (s *Server) Counters() map[interface{}]Counter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m := make(map[interface{}]Counter, len(s.counters))
+	for id, c := range s.counters {
+		m[id] = *c
+	}
+	return m
+}
+
+// counter return the counter of id, the caller should get the lock.
+func (s *Server) counter(id interface{}) *Counter {
+	c, ok := s.counters[id]
+	if !ok {
+		c = new(Counter)
+		s.counters[id] = c
+	}
+	return c
+}
+
+// reject count a rejected call and return ErrBusy.
+func (s *Server) reject(ci *CallInfo) error {
+	s.mutex.Lock()
+	s.counter(ci.id).Rejected++
+	s.mutex.Unlock()
+	return ErrBusy
+}
+
+// drop count a dropped call and return it with errDropped.
+func (s *Server) drop(ci *CallInfo) {
+	s.mutex.Lock()
+	s.counter(ci.id).Dropped++
+	s.mutex.Unlock()
+	s.ret(ci, &RetInfo{err: errDropped})
+}
+
+// push put ci to its lane according the policy. block is true for sync call, wait is the max waiting time of
+// async call under PolicyTimeout.
+func (s *Server) push(ci *CallInfo, block bool, wait time.Duration) (err error) {
+	var done <-chan struct{}
+	if ci.ctx != nil {
+		done = ci.ctx.Done()
+	}
+
+	s.mutex.Lock()
+	ci.priority = s.priorityOf(ci)
+	ch := s.Lane(ci.priority)
+	policy := s.policy
+	if policy == PolicyShed {
+		policy, err = s.shed(ci)
+	}
+	s.mutex.Unlock()
+
+	if err != nil {
+		return s.reject(ci)
+	}
+
+	switch policy {
+	case PolicyBlock:
+		select {
+		case ch <- ci:
+		case <-done:
+			return ci.ctx.Err()
+		}
+	case PolicyReject:
+		select {
+		case ch <- ci:
+		default:
+			return s.reject(ci)
+		}
+	case PolicyDropOldest:
+		for pushed := false; !pushed; {
+			select {
+			case ch <- ci:
+				pushed = true
+			default:
+				select {
+				case old := <-ch:
+					s.drop(old)
+				default:
+				}
+			}
+		}
+	default:
+		if block {
+			select {
+			case ch <- ci:
+			case <-done:
+				return ci.ctx.Err()
+			}
+		} else {
+			// a short wait must not race with a lane having room.
+			select {
+			case ch <- ci:
+				s.checkWatermark()
+				return nil
+			default:
+			}
+
+			select {
+			case ch <- ci:
+			case <-done:
+				return ci.ctx.Err()
+			case <-time.After(wait):
+				return s.reject(ci)
+			}
+		}
+	}
+
+	s.checkWatermark()
+	return nil
+}
+
+// shed return the policy for ci under PolicyShed, or ErrBusy if ci should be shed. The caller should get the lock.
+func (s *Server) shed(ci *CallInfo) (Policy, error) {
+	switch ci.priority {
+	case PrioritySystem:
+		return PolicyBlock, nil
+	case PriorityNormal:
+		return PolicyReject, nil
+	default:
+		level := s.watermark
+		if level <= 0 {
+			level = cap(s.ChanCall) / 2
+		}
+		if s.Depth() >= level {
+			return PolicyReject, ErrBusy
+		}
+		return PolicyReject, nil
+	}
+}
+
+// checkWatermark call onWatermark if the lanes grow over the watermark.
+func (s *Server) checkWatermark() {
+	s.mutex.Lock()
+	depth := s.Depth()
+	if depth > s.maxDepth {
+		s.maxDepth = depth
+	}
+	if s.onWatermark == nil || s.overWatermark || depth < s.watermark {
+		s.mutex.Unlock()
+		return
+	}
+	s.overWatermark = true
+	f := s.onWatermark
+	s.mutex.Unlock()
+
+	f(depth)
+}
+
+// rearmWatermark make onWatermark can be called again when the lanes are below the watermark.
+func (s *Server) rearmWatermark() {
+	s.mutex.Lock()
+	if s.overWatermark && s.Depth() < s.watermark {
+		s.overWatermark = false
+	}
+	s.mutex.Unlock()
+}
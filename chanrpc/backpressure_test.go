@@ -0,0 +1,102 @@
+package chanrpc_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func newIdleServer(bufsize int, p chanrpc.Policy) *chanrpc.Server {
+	s := chanrpc.NewServer(bufsize, 1000)
+	s.SetPolicy(p)
+	s.Register("f", func(args []interface{}) (ret interface{}, err error) {
+		return nil, nil
+	})
+	s.Register("bulk", func(args []interface{}) (ret interface{}, err error) {
+		return nil, nil
+	})
+	return s
+}
+
+func TestPolicyReject(t *testing.T) {
+	// server is not started, so the channel is never consumed.
+	s := newIdleServer(1, chanrpc.PolicyReject)
+	c := chanrpc.NewClient(10, time.Second)
+
+	if err := c.AsynCall(s, "f"); err != nil {
+		t.Error(err)
+	}
+
+	start := time.Now()
+	if err := c.AsynCall(s, "f"); err != chanrpc.ErrBusy {
+		t.Errorf("expect busy, got %v", err)
+	}
+	if time.Since(start) > time.Millisecond*100 {
+		t.Error("reject should not wait for the timeout of client")
+	}
+
+	if n := s.Counter("f").Rejected; n != 1 {
+		t.Errorf("expect 1 rejected, got %v", n)
+	}
+}
+
+func TestPolicyDropOldest(t *testing.T) {
+	s := newIdleServer(1, chanrpc.PolicyDropOldest)
+	c := chanrpc.NewClient(10, time.Second)
+
+	var dropped error
+	c.AsynCall(s, "f", func(ret interface{}, err error) {
+		dropped = err
+	})
+	if err := c.AsynCall(s, "f"); err != nil {
+		t.Error(err)
+	}
+
+	c.Cb(<-c.ChanAsynRet)
+	if dropped == nil {
+		t.Error("expect error of dropped call")
+	}
+	if n := s.Counter("f").Dropped; n != 1 {
+		t.Errorf("expect 1 dropped, got %v", n)
+	}
+}
+
+func TestPolicyShed(t *testing.T) {
+	s := newIdleServer(4, chanrpc.PolicyShed)
+	s.SetPriority("bulk", chanrpc.PriorityBulk)
+
+	depths := []int{}
+	s.SetHighWatermark(2, func(depth int) {
+		depths = append(depths, depth)
+	})
+
+	c := chanrpc.NewClient(10, time.Second)
+
+	for i := 0; i < 2; i++ {
+		if err := c.AsynCall(s, "bulk"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// bulk is shed at the watermark, while normal is still accepted until its lane is full.
+	if err := c.AsynCall(s, "bulk"); err != chanrpc.ErrBusy {
+		t.Errorf("expect busy, got %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := c.AsynCall(s, "f"); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := c.AsynCall(s, "f"); err != chanrpc.ErrBusy {
+		t.Errorf("expect busy, got %v", err)
+	}
+
+	if len(depths) != 1 || depths[0] != 2 {
+		t.Errorf("watermark should be reported once at 2, got %v", depths)
+	}
+
+	counters := s.Counters()
+	if counters["bulk"].Rejected != 1 || counters["f"].Rejected != 1 {
+		t.Errorf("wrong counters %v", counters)
+	}
+}
@@ -0,0 +1,42 @@
+package chanrpc
+
+import (
+	"context"
+)
+
+// Cast do a one-way call to server, the handler is executed but its return is dropped, so the call needs no callback
+// and is not counted as pending. If the lane is full, it waits for the timeout of client, and returns ErrBusy after it.
+func (c *Client) Cast(s *Server, id interface{}, args ...interface{}) error {
+	return c.cast(nil, s, id, args, false)
+}
+
+// CastContext do a one-way call like Cast, but it blocks until the call is accepted or ctx is done. The call is
+// dropped if ctx is done before it is executed.
+func (c *Client) CastContext(ctx context.Context, s *Server, id interface{}, args ...interface{}) error {
+	return c.cast(ctx, s, id, args, true)
+}
+
+// cast do a one-way call to server, ctx may be nil.
+func (c *Client) cast(ctx context.Context, s *Server, id interface{}, args []interface{}, block bool) error {
+	ci := &CallInfo{
+		id:     id,
+		args:   args,
+		ctx:    ctx,
+		caller: c.caller(),
+		seq:    c.nextSeq(),
+	}
+
+	_, err := intercept(c.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		_, err := validate(s, ci.id)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.push(ci, block, c.timeout)
+		if err == ErrBusy {
+			c.skip()
+		}
+		return nil, err
+	})
+	return err
+}
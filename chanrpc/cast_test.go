@@ -0,0 +1,50 @@
+package chanrpc_test
+
+import (
+	"context"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestCast(t *testing.T) {
+	s := chanrpc.NewServer(1, 1000)
+	got := make(chan int, 10)
+	s.Register("put", func(args []interface{}) (interface{}, error) {
+		got <- args[0].(int)
+		return nil, nil
+	})
+
+	c := chanrpc.NewClient(0, 0)
+	if err := c.Cast(s, "put", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cast(s, "put", 2); err != chanrpc.ErrBusy {
+		t.Fatalf("expect ErrBusy, got %v", err)
+	}
+	if err := c.Cast(s, "none"); err == nil {
+		t.Fatal("expect error of unregistered function")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.CastContext(ctx, s, "put", 3); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+
+	served := make(chan bool)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Exec(<-s.ChanCall)
+		served <- true
+	}()
+	if err := c.CastContext(context.Background(), s, "put", 4); err != nil {
+		t.Fatal(err)
+	}
+	<-served
+	s.Exec(<-s.ChanCall)
+
+	if <-got != 1 || <-got != 4 || c.Pending() != 0 || c.Skipped() != 1 {
+		t.Error("unexpected casts")
+	}
+}
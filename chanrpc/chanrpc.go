@@ -2,11 +2,14 @@
 package chanrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/log"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,46 +17,101 @@ import (
 type RpcCommon struct {
 	timeout     time.Duration // timeout of write to channel.
 	SkipCounter int           // counter count the number of skip return when timeout.
+	mutex       sync.Mutex    // mutex protect counters.
+}
+
+// skip increase SkipCounter.
+func (r *RpcCommon) skip() {
+	r.mutex.Lock()
+	r.SkipCounter++
+	r.mutex.Unlock()
+}
+
+// Skipped return SkipCounter, it is goroutine safe.
+func (r *RpcCommon) Skipped() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.SkipCounter
 }
 
 // CallInfo is info of call to server.
 type CallInfo struct {
-	id      interface{}              // call id.
-	args    []interface{}            // call args.
-	chanRet chan *RetInfo            // return channel, such as ci.chanRet <- ri.
-	cb      func(interface{}, error) // callback which will pass to RetInfo.
+	id       interface{}              // call id.
+	args     []interface{}            // call args.
+	chanRet  chan *RetInfo            // return channel, such as ci.chanRet <- ri.
+	cb       func(interface{}, error) // callback which will pass to RetInfo.
+	ctx      context.Context          // context of caller, nil if the caller never gives up.
+	priority Priority                 // priority of lane the call is sent to.
+	onItem   func(interface{}, error) // callback of item of streaming call.
+	stream   *Stream                  // stream of streaming call, set when executing.
+	caller   *Server                  // server whose goroutine made the call, nil if unknown.
+	client   *Client                  // client made the call, nil if unknown.
+	seq      uint64                   // sequence of call in its client.
+	sync     bool                     // the call is sync.
 }
 
 // RetInfo is info of return from server.
 type RetInfo struct {
-	ret interface{}              // value of return.
-	err error                    // err of return.
-	cb  func(interface{}, error) // callback of return.
+	ret   interface{}              // value of return.
+	err   error                    // err of return.
+	cb    func(interface{}, error) // callback of return.
+	extra bool                     // ret is not the final return of call, such as the one of done context or stream item.
+	item  bool                     // ret is a stream item.
+	seq   uint64                   // sequence of the call in its client.
 }
 
 // Server is a chanrpc server.
 type Server struct {
-	functions map[interface{}]interface{} // map id(cmd) to handler.
-	ChanCall  chan *CallInfo              // channel of CallInfo.
-	RpcCommon                             // common variate.
+	functions     map[interface{}]interface{}   // map id(cmd) to handler.
+	signatures    map[interface{}]*signature    // map id(cmd) to types of handler registered by Handle.
+	ChanSystem    chan *CallInfo                // channel of CallInfo of PrioritySystem.
+	ChanCall      chan *CallInfo                // channel of CallInfo of PriorityNormal.
+	ChanBulk      chan *CallInfo                // channel of CallInfo of PriorityBulk.
+	interceptors  []Interceptor                 // interceptors wrap handlers.
+	policy        Policy                        // backpressure policy when lane is full.
+	priorities    map[interface{}]Priority      // map id(cmd) to priority.
+	watermark     int                           // high watermark of calls waiting in all lanes.
+	onWatermark   func(depth int)               // callback when lanes grow over watermark.
+	overWatermark bool                          // lanes are over watermark, onWatermark is called already.
+	counters      map[interface{}]*Counter      // map id(cmd) to calls not executed for backpressure.
+	name          string                        // name used in diagnostics.
+	stats         map[interface{}]*HandlerStats // map id(cmd) to stats of handler.
+	maxDepth      int                           // high-water mark of calls waiting in all lanes.
+	slow          time.Duration                 // threshold of logging slow handler, 0 to disable.
+	busy          int32                         // number of handlers or callbacks running in the loop, see Enter.
+	loop          int64                         // id of goroutine running the loop, known only in deadlock debug.
+	RpcCommon                                   // common variate.
 }
 
-// Client is a chanrpc client.
+// Client is a chanrpc client. Calls can be made from many goroutines, while async returns must be handled by Cb in one
+// goroutine.
 type Client struct {
-	chanSyncRet     chan *RetInfo // channel of RetInfo for sync call.
 	ChanAsynRet     chan *RetInfo // channel of RetInfo for asyn call.
 	pendingAsynCall int           // number of not returned asyn call.
+	interceptors    []Interceptor // interceptors wrap calls.
+	owner           *Server       // server whose goroutine makes the calls, used for deadlock detection.
+	seq             uint64        // sequence of the last call.
+	maxDepth        int           // high-water mark of returns waiting in ChanAsynRet.
+	closeChan       chan struct{} // closed when returns are not handled any more.
+	closeOnce       sync.Once     // close closeChan once.
 	RpcCommon                     // common variate.
 }
 
 // NewServer new a server.
 //
-// bufsize define buffer size of called channel
+// bufsize define buffer size of called channel of each lane
 // timeout define timeout for writing to channel of clent, prevent from infinite blocking.
 func NewServer(bufsize int, timeout time.Duration) *Server {
 	s := new(Server)
 	s.functions = make(map[interface{}]interface{})
+	s.signatures = make(map[interface{}]*signature)
+	s.priorities = make(map[interface{}]Priority)
+	s.counters = make(map[interface{}]*Counter)
+	s.stats = make(map[interface{}]*HandlerStats)
+	s.slow = time.Duration(conf.SlowHandler) * time.Millisecond
+	s.ChanSystem = make(chan *CallInfo, bufsize)
 	s.ChanCall = make(chan *CallInfo, bufsize)
+	s.ChanBulk = make(chan *CallInfo, bufsize)
 	s.timeout = timeout
 	return s
 }
@@ -66,9 +124,10 @@ func (s *Server) Register(id interface{}, f func([]interface{}) (ret interface{}
 	s.functions[id] = f
 }
 
-// SetHandlers
+// SetHandlers replace all handlers with m, types of handlers registered by Handle are dropped.
 func (s *Server) SetHandlers(m map[interface{}]interface{}) {
 	s.functions = m
+	s.signatures = make(map[interface{}]*signature)
 }
 
 // ret write result to channel provided by ci.
@@ -84,11 +143,19 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	}()
 
 	ri.cb = ci.cb
+	ri.seq = ci.seq
+
+	// a timeout of zero must not race with a channel having room.
+	select {
+	case ci.chanRet <- ri:
+		return
+	default:
+	}
 
 	select {
 	case ci.chanRet <- ri:
 	case <-time.After(time.Millisecond * s.timeout):
-		s.SkipCounter++
+		s.skip()
 	}
 	return
 }
@@ -105,28 +172,76 @@ func (s *Server) Exec(ci *CallInfo) (err error) {
 				err = fmt.Errorf("%v", r)
 			}
 			log.Debug("%v", r)
-			s.ret(ci, &RetInfo{err: fmt.Errorf("%v", r)})
+			if ci.stream != nil {
+				ci.stream.Close(fmt.Errorf("%v", r))
+			} else {
+				s.ret(ci, &RetInfo{err: fmt.Errorf("%v", r)})
+			}
 		}
 	}()
 
+	s.Enter()
+	defer s.Leave()
+
+	// the caller has given up, no need to run the handler.
+	if ci.ctx != nil && ci.ctx.Err() != nil {
+		return s.ret(ci, &RetInfo{err: ci.ctx.Err()})
+	}
+
 	f := s.functions[ci.id]
 	if f == nil {
 		panic(fmt.Sprintf("no function for %s", ci.id))
 	}
 
-	ret, err := f.(func([]interface{}) (ret interface{}, err error))(ci.args)
+	s.rearmWatermark()
+
+	// a panic leaves failed true.
+	start, failed := time.Now(), true
+	defer func() {
+		s.observe(ci.id, time.Since(start), failed)
+	}()
+
+	h, streaming := f.(func([]interface{}, *Stream))
+	if streaming != (ci.onItem != nil) {
+		return s.ret(ci, &RetInfo{err: fmt.Errorf("function id %v: mismatched streaming call", ci.id)})
+	}
+	if streaming {
+		failed = false
+		return s.execStream(ci, h)
+	}
+
+	ret, err := intercept(s.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		return f.(func([]interface{}) (ret interface{}, err error))(ci.args)
+	})
+	failed = err != nil
 	return s.ret(ci, &RetInfo{ret: ret, err: err})
 }
 
-// Close do the shutdown of the server.
+// Close do the shutdown of the server, calls left in lanes are executed from the higher lane.
 func (s *Server) Close() {
-	close(s.ChanCall)
-	for ci := range s.ChanCall {
-		err := s.Exec(ci)
-		if err != nil {
-			log.Error("%v", err)
+	for _, ch := range []chan *CallInfo{s.ChanSystem, s.ChanCall, s.ChanBulk} {
+		close(ch)
+		for ci := range ch {
+			err := s.Exec(ci)
+			if err != nil {
+				log.Error("%v", err)
+			}
+		}
+	}
+}
+
+// Abort do the shutdown of the server like Close, but calls left in lanes are returned with err instead of executed. It
+// return the number of calls aborted.
+func (s *Server) Abort(err error) int {
+	n := 0
+	for _, ch := range []chan *CallInfo{s.ChanSystem, s.ChanCall, s.ChanBulk} {
+		close(ch)
+		for ci := range ch {
+			n++
+			s.ret(ci, &RetInfo{err: err})
 		}
 	}
+	return n
 }
 
 // NewClient create a rpc client.
@@ -135,13 +250,47 @@ func (s *Server) Close() {
 // timeout define max wait time when send async call request.
 func NewClient(size int, timeout time.Duration) *Client {
 	c := new(Client)
-	c.chanSyncRet = make(chan *RetInfo, 1)
 	c.ChanAsynRet = make(chan *RetInfo, size)
+	c.closeChan = make(chan struct{})
 	c.timeout = timeout
 	return c
 }
 
-// call send the request call ci to server channel. If block is true, this will wait forever if channel of server is busy.
+// ErrFull is returned when the async call is refused for the client has too many not returned calls.
+var ErrFull = errors.New("too many calls of client")
+
+// ErrNotRegistered is returned when the server has no handler of the call.
+var ErrNotRegistered = errors.New("function not registered")
+
+// chanSyncRetPool pool return channels of sync call, so concurrent sync calls of one client never share a channel.
+var chanSyncRetPool = sync.Pool{
+	New: func() interface{} {
+		return make(chan *RetInfo, 1)
+	},
+}
+
+// nextSeq return the sequence of a new call.
+func (c *Client) nextSeq() uint64 {
+	return atomic.AddUint64(&c.seq, 1)
+}
+
+// pending add delta to the number of not returned call.
+func (c *Client) pending(delta int) {
+	c.mutex.Lock()
+	c.pendingAsynCall += delta
+	c.mutex.Unlock()
+}
+
+// Pending return the number of not returned call, it is goroutine safe.
+func (c *Client) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pendingAsynCall
+}
+
+// call send the request call ci to server channel according the policy of server. If block is true, by default this will
+// wait forever if channel of server is busy, unless the context of ci is done. If block is false, the call is refused
+// when there are too many not returned calls.
 func (c *Client) call(s *Server, ci *CallInfo, block bool) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -149,92 +298,209 @@ func (c *Client) call(s *Server, ci *CallInfo, block bool) (err error) {
 		}
 	}()
 
+	ci.client = c
+
+	c.mutex.Lock()
+	if !block && c.pendingAsynCall > cap(c.ChanAsynRet) {
+		c.mutex.Unlock()
+		return ErrFull
+	}
 	c.pendingAsynCall++
+	c.mutex.Unlock()
 
-	if block {
-		s.ChanCall <- ci
-	} else {
-		select {
-		case s.ChanCall <- ci:
-		case <-time.After(c.timeout):
-			c.SkipCounter++
-			c.pendingAsynCall--
-			err = errors.New("server is too busy.")
+	err = s.push(ci, block, c.timeout)
+	if err != nil {
+		if err == ErrBusy {
+			c.skip()
 		}
+		c.pending(-1)
 	}
 	return
 }
 
 // Call do a sync call to attatched server.
 func (c *Client) SynCall(s *Server, id interface{}, args ...interface{}) (interface{}, error) {
-	_, err := validate(s, id)
-	if err != nil {
-		return nil, err
+	ci := &CallInfo{
+		id:      id,
+		args:    args,
+		chanRet: chanSyncRetPool.Get().(chan *RetInfo),
+		caller:  c.caller(),
+		seq:     c.nextSeq(),
+		sync:    true,
 	}
 
-	err = c.call(s, &CallInfo{
+	return intercept(c.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		_, err := validate(s, ci.id)
+		if err != nil {
+			chanSyncRetPool.Put(ci.chanRet)
+			return nil, err
+		}
+
+		release, err := waitFor(ci.caller, s)
+		if err != nil {
+			chanSyncRetPool.Put(ci.chanRet)
+			return nil, err
+		}
+		defer release()
+
+		err = c.call(s, ci, true)
+		if err != nil {
+			chanSyncRetPool.Put(ci.chanRet)
+			return nil, err
+		}
+
+		// sync.
+		ri, _ := c.waitRet(s, ci.chanRet, nil)
+		chanSyncRetPool.Put(ci.chanRet)
+		c.pending(-1)
+
+		return ri.ret, ri.err
+	})
+}
+
+// SynCallContext do a sync call to server, and give up when ctx is done, both when waiting for the server to accept the
+// call and when waiting for its return.
+func (c *Client) SynCallContext(ctx context.Context, s *Server, id interface{}, args ...interface{}) (interface{}, error) {
+	// the return of a call given up may come late, so its channel is not put back to chanSyncRetPool.
+	ci := &CallInfo{
 		id:      id,
 		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
-
-	if err != nil {
-		return nil, err
+		chanRet: make(chan *RetInfo, 1),
+		ctx:     ctx,
+		caller:  c.caller(),
+		seq:     c.nextSeq(),
+		sync:    true,
 	}
 
-	// sync.
-	ri := <-c.chanSyncRet
-	c.pendingAsynCall--
+	return intercept(c.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		_, err := validate(s, ci.id)
+		if err != nil {
+			return nil, err
+		}
+
+		release, err := waitFor(ci.caller, s)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
 
-	return ri.ret, ri.err
+		err = c.call(s, ci, true)
+		if err != nil {
+			return nil, err
+		}
+
+		ri, ok := c.waitRet(s, ci.chanRet, ctx.Done())
+		c.pending(-1)
+		if !ok {
+			return nil, ctx.Err()
+		}
+		return ri.ret, ri.err
+	})
 }
 
 // AsyncCall do a async call to server.
 func (c *Client) AsynCall(s *Server, id interface{}, _args ...interface{}) error {
-	args := _args[:len(_args)-1]
-	cb := _args[len(_args)-1]
+	return c.asynCall(nil, s, id, _args)
+}
 
-	switch cb.(type) {
-	case func(ret interface{}, err error):
-	default:
-		args = _args
-		cb = nil
-	}
+// AsynCallContext do a async call to server. If ctx is done before the return of server, the callback is called with
+// the error of ctx, and the late return is dropped. The callback is called only once.
+func (c *Client) AsynCallContext(ctx context.Context, s *Server, id interface{}, _args ...interface{}) error {
+	return c.asynCall(ctx, s, id, _args)
+}
+
+// asynCall do a async call to server, ctx may be nil.
+func (c *Client) asynCall(ctx context.Context, s *Server, id interface{}, _args []interface{}) error {
+	args, cb := splitCallback(_args)
 
-	var _cb func(interface{}, error)
-	if cb != nil {
-		_cb = cb.(func(interface{}, error))
+	ci := &CallInfo{
+		id:      id,
+		args:    args,
+		chanRet: c.ChanAsynRet,
+		cb:      cb,
+		ctx:     ctx,
+		caller:  c.caller(),
+		seq:     c.nextSeq(),
 	}
 
-	_, err := validate(s, id)
-	if err != nil {
-		//c.ChanAsynRet <- &RetInfo{err: err, cb: _cb}
-		return fmt.Errorf("no matching function for asynCall call: %v", id)
+	_, err := intercept(c.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		_, err := validate(s, ci.id)
+		if err != nil {
+			//c.ChanAsynRet <- &RetInfo{err: err, cb: ci.cb}
+			return nil, fmt.Errorf("no matching function for asynCall call: %w", err)
+		}
+
+		abandon := func() {}
+		if ctx != nil && ctx.Done() != nil {
+			ci.cb, abandon = c.cancelable(ctx, ci.seq, ci.cb)
+		}
+
+		err = c.call(s, ci, false)
+		if err != nil {
+			// c.ChanAsynRet <- &RetInfo{err: err, cb: ci.cb}
+			abandon()
+			return nil, err
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// cancelable wrap cb, so it is called with the error of ctx once ctx is done, and only the first return is passed to cb.
+// abandon make the wrapped cb never be called, it is used when the call is failed to send.
+func (c *Client) cancelable(ctx context.Context, seq uint64, cb func(interface{}, error)) (wrapped func(interface{}, error), abandon func()) {
+	var mutex sync.Mutex
+	var stop func() bool
+	finished := false
+
+	// finish mark the call finished, and return if it is finished already.
+	finish := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if finished {
+			return true
+		}
+		finished = true
+		stop()
+		return false
 	}
 
-	if c.pendingAsynCall > cap(c.ChanAsynRet) {
-		//c.ChanAsynRet <- &RetInfo{err: errors.New("too many calls of client"), cb: _cb}
-		return fmt.Errorf("too many calls of client")
+	wrapped = func(ret interface{}, err error) {
+		if finish() {
+			return
+		}
+		if cb != nil {
+			cb(ret, err)
+		}
 	}
 
-	err = c.call(s, &CallInfo{
-		id:      id,
-		args:    args,
-		chanRet: c.ChanAsynRet,
-		cb:      _cb,
-	}, false)
+	mutex.Lock()
+	stop = context.AfterFunc(ctx, func() {
+		// counted like the final return, and given up if nobody handles returns any more.
+		c.pending(1)
+		select {
+		case c.ChanAsynRet <- &RetInfo{err: ctx.Err(), cb: wrapped, extra: true, seq: seq}:
+		case <-c.closeChan:
+			c.pending(-1)
+		}
+	})
+	mutex.Unlock()
 
-	if err != nil {
-		// c.ChanAsynRet <- &RetInfo{err: err, cb: _cb}
-		return err
+	abandon = func() {
+		finish()
 	}
 
-	return nil
+	return
 }
 
 // Cb do exec the callback of ri when the async call is finish handled by server.
 func (c *Client) Cb(ri *RetInfo) *RetInfo {
-	c.pendingAsynCall--
+	c.observeDepth(len(c.ChanAsynRet) + 1)
+
+	// extra returns are counted when sent.
+	c.pending(-1)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -254,25 +520,44 @@ func (c *Client) Cb(ri *RetInfo) *RetInfo {
 	return ri
 }
 
-// Close do close goroutine internal if it exist, and do other cleanup job.
+// Close tell c its returns are not handled any more, so goroutines sending extra returns, such as the one of done
+// context, give up.
 func (c *Client) Close() {
 	//The rule of thumb here is that only writers should close channels.
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
 }
 
 // Helper function.
 
+// defaultClient is shared by helper functions, which are called from any goroutine.
+var defaultClient = NewClient(0, 1*time.Second)
+
 // SynCall do a sync call request to server.
 func SynCall(s *Server, id interface{}, args ...interface{}) (interface{}, error) {
-	// !!double_check!!
-	c := NewClient(0, 1*time.Second)
-	return c.SynCall(s, id, args...)
+	return defaultClient.SynCall(s, id, args...)
+}
+
+// splitCallback split the trailing callback from args of async call, callback is nil if there is no one.
+func splitCallback(_args []interface{}) (args []interface{}, cb func(interface{}, error)) {
+	if len(_args) == 0 {
+		return _args, nil
+	}
+
+	switch f := _args[len(_args)-1].(type) {
+	case func(ret interface{}, err error):
+		return _args[:len(_args)-1], f
+	default:
+		return _args, nil
+	}
 }
 
 // validate if the server can handle the id.
 func validate(s *Server, id interface{}) (f interface{}, err error) {
 	f = s.functions[id]
 	if f == nil {
-		err = fmt.Errorf("function id %v: function not registered", id)
+		err = fmt.Errorf("function id %v: %w", id, ErrNotRegistered)
 		return
 	}
 	return
@@ -1,11 +1,13 @@
 package chanrpc_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/LuisZhou/lpge/chanrpc"
 	"github.com/LuisZhou/lpge/log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -247,3 +249,169 @@ func Example() {
 	// 1
 	// 3
 }
+
+func TestSynCallContext(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("slow", func(args []interface{}) (ret interface{}, err error) {
+		time.Sleep(time.Millisecond * 100)
+		return args[0], nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Millisecond*50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := c.SynCallContext(ctx, s, "slow", 1)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+
+	// the late return of the call above must not be read by the next call.
+	ret, err := c.SynCallContext(context.Background(), s, "slow", 2)
+	if err != nil || ret.(int) != 2 {
+		t.Errorf("expect 2, got %v %v", ret, err)
+	}
+}
+
+func TestAsynCallContext(t *testing.T) {
+	closesig := make(chan bool)
+
+	var wg sync.WaitGroup
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("slow", func(args []interface{}) (ret interface{}, err error) {
+		time.Sleep(time.Millisecond * 100)
+		return nil, nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Millisecond*50)
+	Wait(c, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	var counter int32
+	wg.Add(1)
+	c.AsynCallContext(ctx, s, "slow", func(ret interface{}, err error) {
+		atomic.AddInt32(&counter, 1)
+		if err != context.DeadlineExceeded {
+			t.Errorf("expect deadline exceeded, got %v", err)
+		}
+		wg.Done()
+	})
+	wg.Wait()
+
+	// wait for the late return, it should be dropped.
+	time.Sleep(time.Millisecond * 150)
+	if n := atomic.LoadInt32(&counter); n != 1 {
+		t.Errorf("callback called %v times", n)
+	}
+}
+
+func TestSkipGivenUpCall(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	counter := 0
+	s.Register("f", func(args []interface{}) (ret interface{}, err error) {
+		counter++
+		return nil, nil
+	})
+
+	c := chanrpc.NewClient(10, time.Millisecond*50)
+
+	// server is not started, so the call is given up before it is executed.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := c.SynCallContext(ctx, s, "f")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	// the sync call is executed after the given up one.
+	chanrpc.SynCall(s, "f")
+	if counter != 1 {
+		t.Error("handler of given up call should be skipped")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("f", func(args []interface{}) (interface{}, error) {
+		t.Error("aborted call executed")
+		return nil, nil
+	})
+
+	c := chanrpc.NewClient(10, time.Second)
+	for i := 0; i < 3; i++ {
+		c.AsynCall(s, "f", func(ret interface{}, err error) {
+			if err == nil {
+				t.Error("expect error of aborted call")
+			}
+		})
+	}
+
+	if n := s.Abort(fmt.Errorf("aborted")); n != 3 {
+		t.Errorf("expect 3 calls aborted, got %v", n)
+	}
+	for c.Pending() > 0 {
+		c.Cb(<-c.ChanAsynRet)
+	}
+}
+
+func TestDoneContextOfClosedClient(t *testing.T) {
+	// server is not started, so the calls never return.
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("f", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	c := chanrpc.NewClient(1, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		if err := c.AsynCallContext(ctx, s, "f", func(ret interface{}, err error) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// returns of done context are counted, one of them waits for room of ChanAsynRet.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for c.Pending() != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := c.Pending(); n != 4 {
+		t.Fatalf("expect 4 pending, got %v", n)
+	}
+
+	// the waiting one gives up when the client is closed.
+	c.Close()
+	for c.Pending() != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := c.Pending(); n != 3 {
+		t.Fatalf("expect 3 pending, got %v", n)
+	}
+	c.Cb(<-c.ChanAsynRet)
+	if n := c.Pending(); n != 2 {
+		t.Errorf("expect 2 pending, got %v", n)
+	}
+}
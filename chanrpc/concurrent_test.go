@@ -0,0 +1,96 @@
+package chanrpc_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// run with -race to check the client is goroutine safe.
+func TestConcurrentClient(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(100, 1000)
+	s.Register("echo", func(args []interface{}) (ret interface{}, err error) {
+		return args[0], nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(1000, time.Second)
+
+	var wgCb sync.WaitGroup
+	var mutex sync.Mutex
+	returned := 0
+
+	go func() {
+		for ri := range c.ChanAsynRet {
+			c.Cb(ri)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				ret, err := c.SynCall(s, "echo", i*100+j)
+				if err != nil || ret.(int) != i*100+j {
+					t.Errorf("expect %v, got %v %v", i*100+j, ret, err)
+				}
+
+				wgCb.Add(1)
+				err = c.AsynCall(s, "echo", j, func(ret interface{}, err error) {
+					mutex.Lock()
+					returned++
+					mutex.Unlock()
+					wgCb.Done()
+				})
+				if err != nil {
+					wgCb.Done()
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	wgCb.Wait()
+
+	if returned != 500 {
+		t.Errorf("expect 500 returns, got %v", returned)
+	}
+	if c.Pending() != 0 {
+		t.Errorf("expect no pending call, got %v", c.Pending())
+	}
+}
+
+func TestConcurrentSynCallHelper(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("echo", func(args []interface{}) (ret interface{}, err error) {
+		return args[0], nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ret, err := chanrpc.SynCall(s, "echo", i)
+			if err != nil || ret.(int) != i {
+				t.Errorf("expect %v, got %v %v", i, ret, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,201 @@
+package chanrpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/LuisZhou/lpge/log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDeadlock is wrapped by the error returned when a sync call would close a cycle of waiting servers.
+var ErrDeadlock = errors.New("deadlock")
+
+var (
+	waits      = make(map[*Server]map[*Server]int) // wait-for graph, owner of client is waiting for servers.
+	waitsMutex sync.Mutex                          // mutex protect waits.
+	debugWait  int64                               // log wait-for graph when a sync call waits longer, in nanoseconds.
+)
+
+// SetDeadlockDebug make a sync call log the wait-for graph when it waits longer than d, 0 to disable.
+func SetDeadlockDebug(d time.Duration) {
+	atomic.StoreInt64(&debugWait, int64(d))
+}
+
+// debugging return if deadlock debug is on.
+func debugging() bool {
+	return atomic.LoadInt64(&debugWait) > 0
+}
+
+// SetName set the name of server, which is used in diagnostics.
+func (s *Server) SetName(name string) {
+	s.mutex.Lock()
+	s.name = name
+	s.mutex.Unlock()
+}
+
+// Name return the name of server.
+func (s *Server) Name() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.name == "" {
+		return fmt.Sprintf("%p", s)
+	}
+	return s.name
+}
+
+// SetOwner tell the client its sync calls made while the loop of s is running a handler or callback block s, so they
+// take part in deadlock detection, and calls of the client made then carry s as their caller. Calls made while the loop
+// is idle, such as by workers of Go, never block s. A worker calling while the loop is busy is taken as the loop too,
+// unless deadlock debug is on, when the goroutine is checked as well.
+func (c *Client) SetOwner(s *Server) {
+	c.owner = s
+}
+
+// caller return the owner of c if the call is made by the loop of owner, nil if not.
+func (c *Client) caller() *Server {
+	if c.owner == nil || atomic.LoadInt32(&c.owner.busy) == 0 {
+		return nil
+	}
+	if debugging() && atomic.LoadInt64(&c.owner.loop) != goid() {
+		return nil
+	}
+	return c.owner
+}
+
+// Enter mark the loop of s is running a handler or callback until Leave, calls of clients owned by s are taken as made
+// by the loop meanwhile, see SetOwner. Exec does it already, it is needed only for other work of the loop.
+func (s *Server) Enter() {
+	if atomic.AddInt32(&s.busy, 1) == 1 && debugging() {
+		atomic.StoreInt64(&s.loop, goid())
+	}
+}
+
+// Leave mark the loop of s is done with the work begun by Enter.
+func (s *Server) Leave() {
+	atomic.AddInt32(&s.busy, -1)
+}
+
+// goid return the id of the calling goroutine, parsed from its stack trace. It is used only when deadlock debug is on.
+func goid() int64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// Caller return the server whose goroutine made the call, nil if it is unknown.
+func (ci *CallInfo) Caller() *Server {
+	return ci.caller
+}
+
+// waitFor add the edge from owner to s to the wait-for graph, and return the function removing it. owner is the caller
+// of sync call, nothing is added if it is nil. ErrDeadlock is returned if s is waiting for the owner already, directly
+// or not.
+func waitFor(owner *Server, s *Server) (func(), error) {
+	if owner == nil {
+		return func() {}, nil
+	}
+
+	waitsMutex.Lock()
+	defer waitsMutex.Unlock()
+
+	if path := findPath(s, owner, nil); path != nil {
+		names := make([]string, 0, len(path)+1)
+		names = append(names, owner.Name())
+		for _, s1 := range path {
+			names = append(names, s1.Name())
+		}
+		return nil, fmt.Errorf("%w: %s", ErrDeadlock, strings.Join(names, " -> "))
+	}
+
+	edges := waits[owner]
+	if edges == nil {
+		edges = make(map[*Server]int)
+		waits[owner] = edges
+	}
+	edges[s]++
+
+	return func() {
+		waitsMutex.Lock()
+		defer waitsMutex.Unlock()
+		edges[s]--
+		if edges[s] == 0 {
+			delete(edges, s)
+		}
+		if len(edges) == 0 {
+			delete(waits, owner)
+		}
+	}, nil
+}
+
+// findPath return the path from from to to in wait-for graph, from and to included, nil if there is no path. The caller
+// should get waitsMutex.
+func findPath(from *Server, to *Server, visited map[*Server]bool) []*Server {
+	if from == to {
+		return []*Server{to}
+	}
+	if visited == nil {
+		visited = make(map[*Server]bool)
+	}
+	if visited[from] {
+		return nil
+	}
+	visited[from] = true
+
+	for next := range waits[from] {
+		if path := findPath(next, to, visited); path != nil {
+			return append([]*Server{from}, path...)
+		}
+	}
+	return nil
+}
+
+// waitRet wait for the return of sync call on chanRet, done may be nil. The wait-for graph is logged if debug is on and
+// the call waits too long.
+func (c *Client) waitRet(s *Server, chanRet chan *RetInfo, done <-chan struct{}) (*RetInfo, bool) {
+	d := time.Duration(atomic.LoadInt64(&debugWait))
+
+	var expired <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		select {
+		case ri := <-chanRet:
+			return ri, true
+		case <-done:
+			return nil, false
+		case <-expired:
+			expired = nil
+			log.Release("sync call to %v waits longer than %v, wait-for graph:\n%v", s.Name(), d, WaitGraph())
+		}
+	}
+}
+
+// WaitGraph return the wait-for graph of sync calls, one edge per line.
+func WaitGraph() string {
+	waitsMutex.Lock()
+	defer waitsMutex.Unlock()
+
+	var lines []string
+	for owner, edges := range waits {
+		for s, n := range edges {
+			lines = append(lines, fmt.Sprintf("%v -> %v (%v)", owner.Name(), s.Name(), n))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,134 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+// node is a server with a client owned by it, like a module.
+type node struct {
+	s *chanrpc.Server
+	c *chanrpc.Client
+}
+
+func newNode(name string, closesig chan bool) *node {
+	n := &node{
+		s: chanrpc.NewServer(10, 1000),
+		c: chanrpc.NewClient(10, time.Second),
+	}
+	n.s.SetName(name)
+	n.c.SetOwner(n.s)
+	Start(n.s, closesig)
+	return n
+}
+
+func TestDeadlock(t *testing.T) {
+	closesig := make(chan bool)
+	defer close(closesig)
+
+	a := newNode("a", closesig)
+	b := newNode("b", closesig)
+	c := newNode("c", closesig)
+
+	// a -> b -> c -> a
+	a.s.Register("call", func(args []interface{}) (ret interface{}, err error) {
+		return a.c.SynCall(b.s, "call")
+	})
+	b.s.Register("call", func(args []interface{}) (ret interface{}, err error) {
+		return b.c.SynCall(c.s, "call")
+	})
+	c.s.Register("call", func(args []interface{}) (ret interface{}, err error) {
+		return c.c.SynCall(a.s, "call")
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := chanrpc.SynCall(a.s, "call")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, chanrpc.ErrDeadlock) {
+			t.Errorf("expect deadlock, got %v", err)
+		} else if err.Error() != "deadlock: c -> a -> b -> c" {
+			t.Errorf("wrong cycle: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deadlock is not detected")
+	}
+
+	if g := chanrpc.WaitGraph(); g != "" {
+		t.Errorf("wait-for graph should be empty, got %v", g)
+	}
+}
+
+func TestSelfDeadlock(t *testing.T) {
+	closesig := make(chan bool)
+	defer close(closesig)
+
+	a := newNode("a", closesig)
+	a.s.Register("self", func(args []interface{}) (ret interface{}, err error) {
+		return a.c.SynCall(a.s, "self")
+	})
+
+	_, err := chanrpc.SynCall(a.s, "self")
+	if !errors.Is(err, chanrpc.ErrDeadlock) {
+		t.Errorf("expect deadlock, got %v", err)
+	}
+}
+
+func TestWorkerNoDeadlock(t *testing.T) {
+	closesig := make(chan bool)
+	defer close(closesig)
+
+	a := newNode("a", closesig)
+	b := newNode("b", closesig)
+	a.s.Register("ping", func(args []interface{}) (ret interface{}, err error) {
+		return "pong", nil
+	})
+	b.s.Register("call", func(args []interface{}) (ret interface{}, err error) {
+		return b.c.SynCall(a.s, "ping")
+	})
+
+	// a worker of a calling while a is idle blocks no one, a is free to serve b.
+	ret, err := a.c.SynCall(b.s, "call")
+	if err != nil || ret != "pong" {
+		t.Errorf("expect pong, got %v %v", ret, err)
+	}
+	if g := chanrpc.WaitGraph(); g != "" {
+		t.Errorf("wait-for graph should be empty, got %v", g)
+	}
+}
+
+func TestWorkerBusyLoop(t *testing.T) {
+	closesig := make(chan bool)
+	defer close(closesig)
+
+	chanrpc.SetDeadlockDebug(time.Minute)
+	defer chanrpc.SetDeadlockDebug(0)
+
+	a := newNode("a", closesig)
+	b := newNode("b", closesig)
+	hold, held := make(chan bool), make(chan bool)
+	a.s.Register("hold", func(args []interface{}) (ret interface{}, err error) {
+		held <- true
+		<-hold
+		return nil, nil
+	})
+	b.s.Register("graph", func(args []interface{}) (ret interface{}, err error) {
+		return chanrpc.WaitGraph(), nil
+	})
+
+	// the loop of a is busy, a worker of a calling meanwhile is told from the loop in debug.
+	go chanrpc.SynCall(a.s, "hold")
+	<-held
+	defer close(hold)
+
+	ret, err := a.c.SynCall(b.s, "graph")
+	if err != nil || ret != "" {
+		t.Errorf("wait-for graph should be empty, got %v %v", ret, err)
+	}
+}
@@ -0,0 +1,200 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Future is the handle of async call. It is resolved in the goroutine calling Cb of its client, so callbacks of
+// future are called in that goroutine too, and Future is not goroutine safe.
+type Future struct {
+	c    *Client                    // client whose ChanAsynRet resolves the future.
+	done bool                       // the future is resolved.
+	ret  interface{}                // value of return.
+	err  error                      // err of return.
+	cbs  []func(interface{}, error) // callbacks waiting for resolve.
+}
+
+// newFuture create a unresolved future of client c.
+func newFuture(c *Client) *Future {
+	f := new(Future)
+	f.c = c
+	return f
+}
+
+// Completed create a resolved future.
+func Completed(ret interface{}, err error) *Future {
+	f := new(Future)
+	f.resolve(ret, err)
+	return f
+}
+
+// resolve set the result of f and call the waiting callbacks, only the first resolve takes effect.
+func (f *Future) resolve(ret interface{}, err error) {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.ret = ret
+	f.err = err
+
+	cbs := f.cbs
+	f.cbs = nil
+	for _, cb := range cbs {
+		cb(ret, err)
+	}
+}
+
+// AsynCallFuture do a async call to server, and return a future of its return.
+func (c *Client) AsynCallFuture(s *Server, id interface{}, args ...interface{}) *Future {
+	return c.AsynCallFutureContext(nil, s, id, args...)
+}
+
+// AsynCallFutureContext do a async call to server like AsynCallContext, and return a future of its return.
+func (c *Client) AsynCallFutureContext(ctx context.Context, s *Server, id interface{}, args ...interface{}) *Future {
+	f := newFuture(c)
+
+	// do not write to the array of args given by caller.
+	args = append(args[:len(args):len(args)], f.resolve)
+
+	err := c.asynCall(ctx, s, id, args)
+	if err != nil {
+		f.resolve(nil, err)
+	}
+	return f
+}
+
+// Done return if f is resolved.
+func (f *Future) Done() bool {
+	return f.done
+}
+
+// Result return the result of f, it is valid only when f is done.
+func (f *Future) Result() (interface{}, error) {
+	return f.ret, f.err
+}
+
+// OnDone register cb which is called when f is resolved, cb is called at once if f is done already.
+func (f *Future) OnDone(cb func(interface{}, error)) *Future {
+	if f.done {
+		cb(f.ret, f.err)
+	} else {
+		f.cbs = append(f.cbs, cb)
+	}
+	return f
+}
+
+// Then call next with the return of f when f is resolved without error, and return a future of the future returned by
+// next. Error of f skips next and is passed to the returned future.
+func (f *Future) Then(next func(ret interface{}) *Future) *Future {
+	f1 := newFuture(f.c)
+	f.OnDone(func(ret interface{}, err error) {
+		if err != nil {
+			f1.resolve(nil, err)
+			return
+		}
+
+		f2 := next(ret)
+		if f2 == nil {
+			f1.resolve(nil, nil)
+			return
+		}
+		if f1.c == nil {
+			f1.c = f2.c
+		}
+		f2.OnDone(f1.resolve)
+	})
+	return f1
+}
+
+// All return a future resolved with returns of fs in []interface{} when all fs are resolved without error, or resolved
+// with the first error.
+func All(fs ...*Future) *Future {
+	f := newFuture(clientOf(fs))
+	rets := make([]interface{}, len(fs))
+	left := len(fs)
+
+	if left == 0 {
+		f.resolve(rets, nil)
+		return f
+	}
+
+	for i, f1 := range fs {
+		i := i
+		f1.OnDone(func(ret interface{}, err error) {
+			if err != nil {
+				f.resolve(nil, err)
+				return
+			}
+			rets[i] = ret
+			left--
+			if left == 0 {
+				f.resolve(rets, nil)
+			}
+		})
+	}
+	return f
+}
+
+// Any return a future resolved with the first return of fs without error, or resolved with the last error if all fs
+// are failed.
+func Any(fs ...*Future) *Future {
+	f := newFuture(clientOf(fs))
+	left := len(fs)
+
+	if left == 0 {
+		f.resolve(nil, errors.New("no future"))
+		return f
+	}
+
+	for _, f1 := range fs {
+		f1.OnDone(func(ret interface{}, err error) {
+			left--
+			if err == nil {
+				f.resolve(ret, nil)
+			} else if left == 0 {
+				f.resolve(nil, err)
+			}
+		})
+	}
+	return f
+}
+
+// Await wait for f to be resolved, timeout <= 0 means waiting forever. It must be called in the goroutine calling Cb of
+// the client, and it does Cb for other returns while waiting, so callbacks of other calls may be called in Await.
+func (f *Future) Await(timeout time.Duration) (interface{}, error) {
+	if f.done {
+		return f.ret, f.err
+	}
+	if f.c == nil {
+		return nil, errors.New("future has no client to wait")
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for !f.done {
+		select {
+		case ri := <-f.c.ChanAsynRet:
+			f.c.Cb(ri)
+		case <-expired:
+			return nil, errors.New("future await timeout")
+		}
+	}
+	return f.ret, f.err
+}
+
+// clientOf return the client of the first future having one.
+func clientOf(fs []*Future) *Client {
+	for _, f := range fs {
+		if f.c != nil {
+			return f.c
+		}
+	}
+	return nil
+}
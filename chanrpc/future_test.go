@@ -0,0 +1,74 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestFuture(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("add", func(args []interface{}) (ret interface{}, err error) {
+		return args[0].(int) + args[1].(int), nil
+	})
+	s.Register("fail", func(args []interface{}) (ret interface{}, err error) {
+		return nil, errors.New("fail")
+	})
+	s.Register("slow", func(args []interface{}) (ret interface{}, err error) {
+		time.Sleep(time.Millisecond * 100)
+		return nil, nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+
+	// call add, then add again with the return.
+	f := c.AsynCallFuture(s, "add", 1, 2).Then(func(ret interface{}) *chanrpc.Future {
+		return c.AsynCallFuture(s, "add", ret, 3)
+	})
+	ret, err := f.Await(time.Second)
+	if err != nil || ret.(int) != 6 {
+		t.Errorf("expect 6, got %v %v", ret, err)
+	}
+
+	ret, err = chanrpc.All(
+		c.AsynCallFuture(s, "add", 1, 1),
+		c.AsynCallFuture(s, "add", 2, 2),
+		chanrpc.Completed(0, nil),
+	).Await(time.Second)
+	if err != nil {
+		t.Error(err)
+	} else if rets := ret.([]interface{}); rets[0].(int) != 2 || rets[1].(int) != 4 || rets[2].(int) != 0 {
+		t.Errorf("wrong returns %v", rets)
+	}
+
+	_, err = chanrpc.All(c.AsynCallFuture(s, "add", 1, 1), c.AsynCallFuture(s, "fail")).Await(time.Second)
+	if err == nil {
+		t.Error("expect error of all")
+	}
+
+	ret, err = chanrpc.Any(c.AsynCallFuture(s, "fail"), c.AsynCallFuture(s, "add", 1, 1)).Await(time.Second)
+	if err != nil || ret.(int) != 2 {
+		t.Errorf("expect 2, got %v %v", ret, err)
+	}
+
+	// error skips Then.
+	_, err = c.AsynCallFuture(s, "fail").Then(func(ret interface{}) *chanrpc.Future {
+		t.Error("should not be called")
+		return nil
+	}).Await(time.Second)
+	if err == nil {
+		t.Error("expect error")
+	}
+
+	_, err = c.AsynCallFuture(s, "slow").Await(time.Millisecond * 10)
+	if err == nil {
+		t.Error("expect timeout")
+	}
+}
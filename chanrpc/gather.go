@@ -0,0 +1,86 @@
+package chanrpc
+
+import (
+	"context"
+)
+
+// GatherResult is the return of one server of Gather.
+type GatherResult struct {
+	Server *Server     // server called.
+	Ret    interface{} // value of return.
+	Err    error       // err of return, or err of sending the call.
+}
+
+// Gather send call id to every server, and return a future resolved with []GatherResult in the order of servers when
+// all servers return, failures are reported per server. ctx is the shared deadline, servers not returned in time get the
+// error of ctx, no deadline if ctx is nil. Calls in flight are limited to the size of ChanAsynRet, the left are sent
+// when the former return, or get ErrBusy if the client is full of other calls. It must be called in the goroutine
+// calling Cb, like Future.
+func (c *Client) Gather(ctx context.Context, servers []*Server, id interface{}, args ...interface{}) *Future {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	f := newFuture(c)
+	results := make([]GatherResult, len(servers))
+	for i, s := range servers {
+		results[i].Server = s
+	}
+
+	if len(servers) == 0 {
+		f.resolve(results, nil)
+		return f
+	}
+
+	left := len(servers)
+	record := func(i int, ret interface{}, err error) {
+		results[i].Ret = ret
+		results[i].Err = err
+		left--
+		if left == 0 {
+			f.resolve(results, nil)
+		}
+	}
+
+	next := 0
+	inflight := 0
+
+	var send func()
+	send = func() {
+		for next < len(servers) {
+			// wait for returns to free ChanAsynRet, the left are refused if nothing of us is in flight to free it.
+			if c.Pending() >= cap(c.ChanAsynRet) {
+				if inflight > 0 {
+					return
+				}
+				for ; next < len(servers); next++ {
+					record(next, nil, ErrBusy)
+				}
+				return
+			}
+
+			i := next
+			next++
+
+			err := ctx.Err()
+			if err == nil {
+				inflight++
+				err = c.AsynCallContext(ctx, servers[i], id, append(args[:len(args):len(args)], func(ret interface{}, err error) {
+					inflight--
+					record(i, ret, err)
+					send()
+				})...)
+				if err != nil {
+					inflight--
+				}
+			}
+
+			if err != nil {
+				record(i, nil, err)
+			}
+		}
+	}
+
+	send()
+	return f
+}
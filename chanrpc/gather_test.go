@@ -0,0 +1,91 @@
+package chanrpc_test
+
+import (
+	"context"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestGather(t *testing.T) {
+	closesig := make(chan bool)
+	defer close(closesig)
+
+	var servers []*chanrpc.Server
+	for i := 0; i < 6; i++ {
+		i := i
+		s := chanrpc.NewServer(10, 1000)
+		switch i {
+		case 4:
+			// not registered, partial failure.
+		case 5:
+			s.Register("online", func(args []interface{}) (ret interface{}, err error) {
+				time.Sleep(time.Millisecond * 200)
+				return i, nil
+			})
+		default:
+			s.Register("online", func(args []interface{}) (ret interface{}, err error) {
+				return i, nil
+			})
+		}
+		Start(s, closesig)
+		servers = append(servers, s)
+	}
+
+	// only 1 slot of async return, calls must be sent in turn.
+	c := chanrpc.NewClient(1, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	ret, err := c.Gather(ctx, servers, "online").Await(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := ret.([]chanrpc.GatherResult)
+	for i, r := range results {
+		if r.Server != servers[i] {
+			t.Errorf("result %v of wrong server", i)
+		}
+		switch i {
+		case 4:
+			if r.Err == nil {
+				t.Error("expect error of not registered")
+			}
+		case 5:
+			if r.Err != context.DeadlineExceeded {
+				t.Errorf("expect deadline exceeded, got %v", r.Err)
+			}
+		default:
+			if r.Err != nil || r.Ret.(int) != i {
+				t.Errorf("expect %v, got %v %v", i, r.Ret, r.Err)
+			}
+		}
+	}
+}
+
+func TestGatherBusy(t *testing.T) {
+	// server is not started, so the call never returns.
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("online", func(args []interface{}) (ret interface{}, err error) {
+		return nil, nil
+	})
+
+	c := chanrpc.NewClient(1, time.Second)
+	if err := c.AsynCall(s, "online", func(ret interface{}, err error) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client is full of other calls, and no deadline is given.
+	f := c.Gather(nil, []*chanrpc.Server{s, s}, "online")
+	if !f.Done() {
+		t.Fatal("expect gather refused at once")
+	}
+	ret, _ := f.Result()
+	for i, r := range ret.([]chanrpc.GatherResult) {
+		if r.Err != chanrpc.ErrBusy {
+			t.Errorf("expect %v of result %v, got %v", chanrpc.ErrBusy, i, r.Err)
+		}
+	}
+}
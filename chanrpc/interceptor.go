@@ -0,0 +1,54 @@
+package chanrpc
+
+import (
+	"context"
+)
+
+// Invoker execute the call ci and return its result.
+type Invoker func(ci *CallInfo) (interface{}, error)
+
+// Interceptor wraps an Invoker with cross-cutting behavior, such as logging, timing, validation or access control. An
+// interceptor must call next to continue the call, or return its own result to stop it.
+//
+// On Server, next runs the handler. On Client, next sends the call, and for a sync call it also waits for the return,
+// while for a async call it returns nil and the error of sending, the return is still passed to the callback.
+type Interceptor func(ci *CallInfo, next Invoker) (interface{}, error)
+
+// Id return the call id.
+func (ci *CallInfo) Id() interface{} {
+	return ci.id
+}
+
+// Args return the call args.
+func (ci *CallInfo) Args() []interface{} {
+	return ci.args
+}
+
+// Context return the context of caller, it is context.Background() if the caller did not give one.
+func (ci *CallInfo) Context() context.Context {
+	if ci.ctx == nil {
+		return context.Background()
+	}
+	return ci.ctx
+}
+
+// Use append interceptors which wrap every handler of the server. The first one is the outermost.
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// Use append interceptors which wrap every call of the client. The first one is the outermost.
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// intercept pass ci through interceptors, and call last at the end of the chain.
+func intercept(interceptors []Interceptor, ci *CallInfo, last Invoker) (interface{}, error) {
+	if len(interceptors) == 0 {
+		return last(ci)
+	}
+
+	return interceptors[0](ci, func(ci *CallInfo) (interface{}, error) {
+		return intercept(interceptors[1:], ci, last)
+	})
+}
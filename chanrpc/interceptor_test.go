@@ -0,0 +1,86 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestServerInterceptor(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("add", func(args []interface{}) (ret interface{}, err error) {
+		return args[0].(int) + args[1].(int), nil
+	})
+	s.Register("admin", func(args []interface{}) (ret interface{}, err error) {
+		return "ok", nil
+	})
+
+	var trace []string
+	s.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		trace = append(trace, "outer")
+		return next(ci)
+	}, func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		trace = append(trace, "inner")
+		if ci.Id() == "admin" {
+			return nil, errors.New("permission denied")
+		}
+		return next(ci)
+	})
+
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	ret, err := chanrpc.SynCall(s, "add", 1, 2)
+	if err != nil || ret.(int) != 3 {
+		t.Errorf("expect 3, got %v %v", ret, err)
+	}
+	if len(trace) != 2 || trace[0] != "outer" || trace[1] != "inner" {
+		t.Errorf("wrong order of interceptors: %v", trace)
+	}
+
+	_, err = chanrpc.SynCall(s, "admin")
+	if err == nil || err.Error() != "permission denied" {
+		t.Errorf("expect permission denied, got %v", err)
+	}
+}
+
+func TestClientInterceptor(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("echo", func(args []interface{}) (ret interface{}, err error) {
+		return args[0], nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+	counter := 0
+	c.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		counter++
+		if len(ci.Args()) != 1 {
+			return nil, errors.New("invalid args")
+		}
+		return next(ci)
+	})
+
+	ret, err := c.SynCall(s, "echo", 1)
+	if err != nil || ret.(int) != 1 {
+		t.Errorf("expect 1, got %v %v", ret, err)
+	}
+
+	if err := c.AsynCall(s, "echo", 1, 2, func(interface{}, error) {}); err == nil {
+		t.Error("expect invalid args")
+	}
+
+	if counter != 2 {
+		t.Errorf("expect 2 intercepted calls, got %v", counter)
+	}
+}
@@ -0,0 +1,81 @@
+package chanrpc
+
+import (
+	"fmt"
+)
+
+// Seq return the sequence of call in its client, calls made in the same order get the same sequences, so the return
+// of a call can be matched when it is replayed.
+func (ci *CallInfo) Seq() uint64 {
+	return ci.seq
+}
+
+// IsSync return if the call is sync, the result of a sync call is returned by the Invoker of interceptor.
+func (ci *CallInfo) IsSync() bool {
+	return ci.sync
+}
+
+// Deliver call the callback of async call ci with ret and err in the calling goroutine, or the item callback if item
+// is true. It is used to replay a recorded return of a call which is never sent.
+func (ci *CallInfo) Deliver(ret interface{}, err error, item bool) {
+	if item {
+		if ci.onItem != nil {
+			ci.onItem(ret, nil)
+		}
+		return
+	}
+	if ci.cb != nil {
+		ci.cb(ret, err)
+	}
+}
+
+// Seq return the sequence of call the return belongs to.
+func (ri *RetInfo) Seq() uint64 {
+	return ri.seq
+}
+
+// Ret return the value of return.
+func (ri *RetInfo) Ret() interface{} {
+	return ri.ret
+}
+
+// Err return the err of return.
+func (ri *RetInfo) Err() error {
+	return ri.err
+}
+
+// IsItem return if the return is a stream item.
+func (ri *RetInfo) IsItem() bool {
+	return ri.item
+}
+
+// Invoke execute handler of id with args in the calling goroutine, through interceptors of server, and return its
+// result. Items and terminal of streaming handler are dropped.
+func (s *Server) Invoke(id interface{}, args ...interface{}) (interface{}, error) {
+	ci := &CallInfo{
+		id:      id,
+		args:    args,
+		chanRet: make(chan *RetInfo, 1),
+	}
+
+	f := s.functions[id]
+	if f == nil {
+		return nil, fmt.Errorf("function id %v: %w", id, ErrNotRegistered)
+	}
+	if _, streaming := f.(func([]interface{}, *Stream)); streaming {
+		ci.chanRet = nil
+		ci.onItem = func(interface{}, error) {}
+	}
+
+	err := s.Exec(ci)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ri := <-ci.chanRet:
+		return ri.ret, ri.err
+	default:
+		return nil, nil
+	}
+}
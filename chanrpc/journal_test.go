@@ -0,0 +1,63 @@
+package chanrpc_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestInvoke(t *testing.T) {
+	s := chanrpc.NewServer(10, 0)
+	s.Register("add", func(args []interface{}) (ret interface{}, err error) {
+		return args[0].(int) + args[1].(int), nil
+	})
+	s.RegisterStream("count", func(args []interface{}, st *chanrpc.Stream) {
+		st.Send(1)
+		st.Close(nil)
+	})
+
+	for i := 0; i < 10; i++ {
+		ret, err := s.Invoke("add", 1, 2)
+		if err != nil || ret.(int) != 3 {
+			t.Fatalf("expect 3, got %v %v", ret, err)
+		}
+	}
+	if _, err := s.Invoke("count"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Invoke("none"); err == nil {
+		t.Fatal("expect error of unregistered function")
+	}
+}
+
+func TestSeq(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.Register("echo", func(args []interface{}) (ret interface{}, err error) {
+		return args[0], nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	var seqs []uint64
+	c := chanrpc.NewClient(10, time.Second)
+	c.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		seqs = append(seqs, ci.Seq())
+		return next(ci)
+	})
+
+	c.SynCall(s, "echo", 1)
+	c.AsynCall(s, "echo", 2, func(ret interface{}, err error) {})
+	ri := <-c.ChanAsynRet
+	if ri.Seq() != 2 || ri.Ret().(int) != 2 || ri.Err() != nil || ri.IsItem() {
+		t.Errorf("unexpected return %v %v %v", ri.Seq(), ri.Ret(), ri.Err())
+	}
+	c.Cb(ri)
+
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+		t.Errorf("unexpected sequences %v", seqs)
+	}
+}
@@ -0,0 +1,78 @@
+package chanrpc
+
+import (
+	"context"
+)
+
+// quantum is the max number of calls of higher lanes served before a call of lower lane, so lower lanes never starve.
+const quantum = 8
+
+// priorityKey is the key of priority in context.
+type priorityKey struct{}
+
+// WithPriority return a context making calls with it in lane of p, which overrides the priority of call id.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityOf return priority of ci, from its context first, then its id. The caller should get the lock.
+func (s *Server) priorityOf(ci *CallInfo) Priority {
+	if ci.ctx != nil {
+		if p, ok := ci.ctx.Value(priorityKey{}).(Priority); ok {
+			return p
+		}
+	}
+	if p, ok := s.priorities[ci.id]; ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// Lane return the channel of calls of priority p. ChanCall is the lane of PriorityNormal.
+func (s *Server) Lane(p Priority) chan *CallInfo {
+	switch p {
+	case PrioritySystem:
+		return s.ChanSystem
+	case PriorityBulk:
+		return s.ChanBulk
+	default:
+		return s.ChanCall
+	}
+}
+
+// Depth return the number of calls waiting in all lanes.
+func (s *Server) Depth() int {
+	return len(s.ChanSystem) + len(s.ChanCall) + len(s.ChanBulk)
+}
+
+// Serve execute ci received from any lane, but calls waiting in higher lanes are executed first, at most quantum of
+// them, so a loop receiving from all lanes services higher lanes first, while lower lanes still get their turn.
+func (s *Server) Serve(ci *CallInfo) {
+	for n := 0; n < quantum && ci.priority != PrioritySystem; n++ {
+		ci1 := s.poll(ci.priority)
+		if ci1 == nil {
+			break
+		}
+		s.Exec(ci1)
+	}
+
+	s.Exec(ci)
+}
+
+// poll receive a call waiting in lanes higher than p, return nil if there is none.
+func (s *Server) poll(p Priority) *CallInfo {
+	select {
+	case ci := <-s.ChanSystem:
+		return ci
+	default:
+	}
+
+	if p == PriorityBulk {
+		select {
+		case ci := <-s.ChanCall:
+			return ci
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package chanrpc_test
+
+import (
+	"context"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestLanes(t *testing.T) {
+	s := chanrpc.NewServer(20, 1000)
+
+	var order []string
+	for _, id := range []string{"system", "normal", "bulk"} {
+		id := id
+		s.Register(id, func(args []interface{}) (ret interface{}, err error) {
+			order = append(order, id)
+			return nil, nil
+		})
+	}
+	s.SetPriority("system", chanrpc.PrioritySystem)
+	s.SetPriority("bulk", chanrpc.PriorityBulk)
+
+	c := chanrpc.NewClient(20, time.Second)
+	c.AsynCall(s, "bulk")
+	c.AsynCall(s, "normal")
+	c.AsynCall(s, "system")
+	// priority of context overrides the one of id.
+	c.AsynCallContext(chanrpc.WithPriority(context.Background(), chanrpc.PrioritySystem), s, "normal")
+
+	s.Serve(<-s.ChanBulk)
+
+	expect := []string{"system", "normal", "normal", "bulk"}
+	if len(order) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, order)
+	}
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, order)
+		}
+	}
+}
+
+func TestLanesStarvation(t *testing.T) {
+	s := chanrpc.NewServer(20, 1000)
+
+	var order []string
+	for _, id := range []string{"system", "bulk"} {
+		id := id
+		s.Register(id, func(args []interface{}) (ret interface{}, err error) {
+			order = append(order, id)
+			return nil, nil
+		})
+	}
+	s.SetPriority("system", chanrpc.PrioritySystem)
+	s.SetPriority("bulk", chanrpc.PriorityBulk)
+
+	c := chanrpc.NewClient(20, time.Second)
+	c.AsynCall(s, "bulk")
+	for i := 0; i < 20; i++ {
+		c.AsynCall(s, "system")
+	}
+
+	s.Serve(<-s.ChanBulk)
+
+	if order[len(order)-1] != "bulk" || len(order) >= 21 {
+		t.Errorf("bulk call should be served before all system calls, got %v", order)
+	}
+}
@@ -0,0 +1,148 @@
+package chanrpc
+
+import (
+	"github.com/LuisZhou/lpge/log"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of buckets of latency histogram.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Histogram is a histogram of latency. Counts[i] counts latencies not above LatencyBuckets[i], and the last one counts
+// latencies above all buckets.
+type Histogram struct {
+	Counts []int         // counts of buckets.
+	Sum    time.Duration // sum of latencies.
+	Max    time.Duration // max latency.
+}
+
+// observe add latency d to histogram.
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int, len(LatencyBuckets)+1)
+	}
+
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean return the mean latency.
+func (h Histogram) Mean() time.Duration {
+	n := 0
+	for _, c := range h.Counts {
+		n += c
+	}
+	if n == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(n)
+}
+
+// HandlerStats is the stats of handler of one id.
+type HandlerStats struct {
+	Calls   int       // calls executed.
+	Errors  int       // calls returned error or panicked.
+	Latency Histogram // time of executing handler, including interceptors.
+}
+
+// ServerStats is a snapshot of stats of server.
+type ServerStats struct {
+	Name     string                       // name of server.
+	Handlers map[interface{}]HandlerStats // stats of handlers having calls executed.
+	Depth    int                          // calls waiting in all lanes.
+	MaxDepth int                          // high-water mark of Depth.
+	Skipped  int                          // returns skipped as the caller is too slow, the SkipCounter.
+	Counters map[interface{}]Counter      // calls not executed for backpressure.
+}
+
+// ClientStats is a snapshot of stats of client.
+type ClientStats struct {
+	Pending  int // calls not returned.
+	Depth    int // returns waiting in ChanAsynRet.
+	MaxDepth int // high-water mark of Depth, observed when returns are handled by Cb.
+	Skipped  int // calls rejected by busy server, the SkipCounter.
+}
+
+// SetSlowThreshold make handler running longer than d logged with its id, 0 to disable. The default is
+// conf.SlowHandler.
+func (s *Server) SetSlowThreshold(d time.Duration) {
+	s.mutex.Lock()
+	s.slow = d
+	s.mutex.Unlock()
+}
+
+// Stats return the stats of server, it is goroutine safe.
+func (s *Server) Stats() ServerStats {
+	st := ServerStats{
+		Name:     s.Name(),
+		Handlers: make(map[interface{}]HandlerStats),
+		Counters: s.Counters(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, h := range s.stats {
+		c := *h
+		c.Latency.Counts = append([]int(nil), h.Latency.Counts...)
+		st.Handlers[id] = c
+	}
+	st.Depth = s.Depth()
+	st.MaxDepth = s.maxDepth
+	st.Skipped = s.SkipCounter
+	return st
+}
+
+// observe add a call of id executed in d to stats.
+func (s *Server) observe(id interface{}, d time.Duration, failed bool) {
+	s.mutex.Lock()
+	h, ok := s.stats[id]
+	if !ok {
+		h = new(HandlerStats)
+		s.stats[id] = h
+	}
+	h.Calls++
+	if failed {
+		h.Errors++
+	}
+	h.Latency.observe(d)
+	slow := s.slow
+	s.mutex.Unlock()
+
+	if slow > 0 && d > slow {
+		log.Release("slow handler %v of server %v: %v", id, s.Name(), d)
+	}
+}
+
+// Stats return the stats of client, it is goroutine safe.
+func (c *Client) Stats() ClientStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return ClientStats{
+		Pending:  c.pendingAsynCall,
+		Depth:    len(c.ChanAsynRet),
+		MaxDepth: c.maxDepth,
+		Skipped:  c.SkipCounter,
+	}
+}
+
+// observeDepth update the high-water mark of ChanAsynRet with depth.
+func (c *Client) observeDepth(depth int) {
+	c.mutex.Lock()
+	if depth > c.maxDepth {
+		c.maxDepth = depth
+	}
+	c.mutex.Unlock()
+}
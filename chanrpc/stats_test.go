@@ -0,0 +1,61 @@
+package chanrpc_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestServerStats(t *testing.T) {
+	s := chanrpc.NewServer(10, 1000)
+	s.SetName("stats")
+	s.SetSlowThreshold(time.Millisecond)
+	s.Register("ok", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	s.Register("fail", func(args []interface{}) (interface{}, error) {
+		return nil, errors.New("fail")
+	})
+	s.Register("slow", func(args []interface{}) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+	s.Register("panic", func(args []interface{}) (interface{}, error) {
+		panic("panic")
+	})
+
+	c := chanrpc.NewClient(10, time.Second)
+	for _, id := range []string{"ok", "ok", "fail", "slow", "panic"} {
+		if err := c.AsynCall(s, id, func(interface{}, error) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for s.Depth() > 0 {
+		s.Exec(<-s.ChanCall)
+	}
+	for c.Pending() > 0 {
+		c.Cb(<-c.ChanAsynRet)
+	}
+
+	st := s.Stats()
+	if st.Name != "stats" || st.MaxDepth != 5 || st.Depth != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if h := st.Handlers["ok"]; h.Calls != 2 || h.Errors != 0 || h.Latency.Counts[0] != 2 {
+		t.Errorf("unexpected stats of ok %+v", h)
+	}
+	if h := st.Handlers["fail"]; h.Calls != 1 || h.Errors != 1 {
+		t.Errorf("unexpected stats of fail %+v", h)
+	}
+	if h := st.Handlers["panic"]; h.Calls != 1 || h.Errors != 1 {
+		t.Errorf("unexpected stats of panic %+v", h)
+	}
+	if h := st.Handlers["slow"]; h.Latency.Max < 5*time.Millisecond || h.Latency.Counts[2] != 1 || h.Latency.Mean() != h.Latency.Max {
+		t.Errorf("unexpected stats of slow %+v", h)
+	}
+
+	if cs := c.Stats(); cs.Pending != 0 || cs.MaxDepth != 5 {
+		t.Errorf("unexpected stats of client %+v", cs)
+	}
+}
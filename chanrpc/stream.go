@@ -0,0 +1,174 @@
+package chanrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errStreamClosed is returned when sending to a closed stream.
+var errStreamClosed = errors.New("stream closed")
+
+// Stream is the sink of a streaming handler. Items sent are passed to the caller in order, until the stream is closed
+// with a terminal error, nil for complete. A stream can be kept by the handler and sent later from any goroutine, but it
+// must be closed at last.
+type Stream struct {
+	s      *Server    // server of the call.
+	ci     *CallInfo  // call of the stream.
+	mutex  sync.Mutex // mutex keep items in order and never after close.
+	closed bool       // the terminal is sent.
+}
+
+// RegisterStream register streaming handler for id. The handler get a Stream to send items to the caller.
+func (s *Server) RegisterStream(id interface{}, f func(args []interface{}, st *Stream)) {
+	if _, ok := s.functions[id]; ok {
+		panic(fmt.Sprintf("function id %v: already registered", id))
+	}
+	s.functions[id] = f
+}
+
+// execStream execute streaming handler f, the stream is closed by interceptor if f is not called.
+func (s *Server) execStream(ci *CallInfo, f func([]interface{}, *Stream)) error {
+	st := &Stream{s: s, ci: ci}
+	ci.stream = st
+
+	called := false
+	_, err := intercept(s.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		called = true
+		f(ci.args, st)
+		return nil, nil
+	})
+
+	if err != nil || !called {
+		st.Close(err)
+	}
+	return nil
+}
+
+// Context return the context of caller, it is done when the caller cancels the stream.
+func (st *Stream) Context() context.Context {
+	return st.ci.Context()
+}
+
+// Send send item to the caller, it waits for the return channel of caller at most the timeout of server, or ErrBusy is
+// returned, so a caller not handling returns, such as the server itself or one blocked in a sync call to it, never
+// hangs the stream. Items are counted as pending calls of caller until handled. If the caller cancels the stream, the
+// stream is closed with the error of context, which is returned.
+func (st *Stream) Send(item interface{}) (err error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if st.closed {
+		return errStreamClosed
+	}
+	if st.ci.chanRet == nil {
+		return nil
+	}
+
+	// no more item after the caller cancels, so the terminal finds room.
+	ctx := st.ci.Context()
+	if err = ctx.Err(); err != nil {
+		st.close(err)
+		return err
+	}
+
+	ri := &RetInfo{ret: item, cb: st.ci.onItem, extra: true, item: true, seq: st.ci.seq}
+	c := st.ci.client
+	if c != nil {
+		c.pending(1)
+	}
+
+	// a timeout of zero must not race with a channel having room.
+	select {
+	case st.ci.chanRet <- ri:
+		return nil
+	default:
+	}
+
+	t := time.NewTimer(time.Millisecond * st.s.timeout)
+	defer t.Stop()
+
+	select {
+	case st.ci.chanRet <- ri:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+		st.close(err)
+	case <-t.C:
+		st.s.skip()
+		err = ErrBusy
+	}
+	if c != nil {
+		c.pending(-1)
+	}
+	return err
+}
+
+// Close end the stream with err, nil for complete. Only the first close takes effect.
+func (st *Stream) Close(err error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.close(err)
+}
+
+// close send the terminal of stream, the caller should get the lock.
+func (st *Stream) close(err error) {
+	if st.closed {
+		return
+	}
+	st.closed = true
+	st.s.ret(st.ci, &RetInfo{err: err})
+}
+
+// Stream do a streaming call to server. onItem is called with each item in order, then onDone is called once with the
+// terminal error, nil for complete. Both are called in the goroutine of Cb. The returned cancel stops the stream, no
+// item is passed after cancel, and onDone gets context.Canceled.
+func (c *Client) Stream(s *Server, id interface{}, onItem func(interface{}), onDone func(error), args ...interface{}) (cancel func(), err error) {
+	return c.StreamContext(context.Background(), s, id, onItem, onDone, args...)
+}
+
+// StreamContext do a streaming call to server like Stream, and the stream is stopped when ctx is done.
+func (c *Client) StreamContext(ctx context.Context, s *Server, id interface{}, onItem func(interface{}), onDone func(error), args ...interface{}) (func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	seq := c.nextSeq()
+
+	done, abandon := c.cancelable(ctx, seq, func(ret interface{}, err error) {
+		cancel()
+		if onDone != nil {
+			onDone(err)
+		}
+	})
+
+	ci := &CallInfo{
+		id:      id,
+		args:    args,
+		chanRet: c.ChanAsynRet,
+		cb:      done,
+		ctx:     ctx,
+		caller:  c.caller(),
+		seq:     seq,
+		onItem: func(item interface{}, _ error) {
+			// items sent before cancel may still be in channel.
+			if ctx.Err() == nil && onItem != nil {
+				onItem(item)
+			}
+		},
+	}
+
+	_, err := intercept(c.interceptors, ci, func(ci *CallInfo) (interface{}, error) {
+		_, err := validate(s, ci.id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.call(s, ci, false)
+	})
+
+	if err != nil {
+		abandon()
+		cancel()
+		return nil, err
+	}
+	return cancel, nil
+}
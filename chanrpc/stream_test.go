@@ -0,0 +1,165 @@
+package chanrpc_test
+
+import (
+	"context"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	closesig := make(chan bool)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.RegisterStream("count", func(args []interface{}, st *chanrpc.Stream) {
+		for i := 0; i < args[0].(int); i++ {
+			if st.Send(i) != nil {
+				return
+			}
+		}
+		st.Close(nil)
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+
+	var items []int
+	done := false
+	_, err := c.Stream(s, "count", func(item interface{}) {
+		items = append(items, item.(int))
+	}, func(err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		done = true
+	}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for !done {
+		c.Cb(<-c.ChanAsynRet)
+	}
+	if len(items) != 20 {
+		t.Fatalf("expect 20 items, got %v", items)
+	}
+	for i, item := range items {
+		if item != i {
+			t.Fatalf("items out of order: %v", items)
+		}
+	}
+	if c.Pending() != 0 {
+		t.Errorf("expect no pending call, got %v", c.Pending())
+	}
+
+	// sync call to a streaming handler is refused.
+	if _, err := c.SynCall(s, "count", 1); err == nil {
+		t.Error("expect error of sync call to stream")
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	closesig := make(chan bool)
+
+	stopped := make(chan error, 1)
+
+	s := chanrpc.NewServer(10, 1000)
+	s.RegisterStream("forever", func(args []interface{}, st *chanrpc.Stream) {
+		go func() {
+			for {
+				if err := st.Send(0); err != nil {
+					stopped <- err
+					return
+				}
+			}
+		}()
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+
+	items := 0
+	var result error
+	done := false
+	cancel, err := c.Stream(s, "forever", func(item interface{}) {
+		items++
+	}, func(err error) {
+		result = err
+		done = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for items < 5 {
+		c.Cb(<-c.ChanAsynRet)
+	}
+	cancel()
+	n := items
+
+	for !done {
+		c.Cb(<-c.ChanAsynRet)
+	}
+	if result != context.Canceled {
+		t.Errorf("expect canceled, got %v", result)
+	}
+	if items != n {
+		t.Error("no item should be passed after cancel")
+	}
+
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("expect canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stream is not stopped by cancel")
+	}
+}
+
+func TestStreamBusy(t *testing.T) {
+	closesig := make(chan bool)
+
+	stopped := make(chan error, 1)
+
+	s := chanrpc.NewServer(10, 10)
+	s.RegisterStream("flood", func(args []interface{}, st *chanrpc.Stream) {
+		for {
+			if err := st.Send(0); err != nil {
+				stopped <- err
+				st.Close(err)
+				return
+			}
+		}
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	// the caller never handles returns, like a server streaming to itself.
+	c := chanrpc.NewClient(2, time.Second)
+	if _, err := c.Stream(s, "flood", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-stopped:
+		if err != chanrpc.ErrBusy {
+			t.Errorf("expect %v, got %v", chanrpc.ErrBusy, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream is blocked by caller")
+	}
+
+	// the call and items sent are pending.
+	if n := c.Pending(); n != 3 {
+		t.Errorf("expect 3 pending, got %v", n)
+	}
+}
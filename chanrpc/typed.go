@@ -0,0 +1,120 @@
+package chanrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// signature is the types of handler registered by Handle.
+type signature struct {
+	req  reflect.Type // type of the only argument.
+	resp reflect.Type // type of return.
+}
+
+// Handle register a typed handler for id, the handler takes exactly one argument of type Req. Handlers registered by
+// Handle can still be called by SynCall and AsynCall, the argument is checked before f is called.
+func Handle[Req, Resp any](s *Server, id interface{}, f func(Req) (Resp, error)) {
+	s.Register(id, func(args []interface{}) (interface{}, error) {
+		req, err := argOf[Req](id, args)
+		if err != nil {
+			return nil, err
+		}
+		return f(req)
+	})
+	s.signatures[id] = &signature{req: typeOf[Req](), resp: typeOf[Resp]()}
+}
+
+// Call do a typed sync call to server. The types of req and Resp are checked against the handler registered by
+// Handle before the call is sent.
+func Call[Resp, Req any](c *Client, s *Server, id interface{}, req Req) (resp Resp, err error) {
+	err = check[Resp, Req](s, id)
+	if err != nil {
+		return
+	}
+
+	ret, err := c.SynCall(s, id, req)
+	if err != nil {
+		return
+	}
+	return retOf[Resp](id, ret)
+}
+
+// CallAsyn do a typed async call to server, cb is called with the typed return in the goroutine of Cb.
+func CallAsyn[Resp, Req any](c *Client, s *Server, id interface{}, req Req, cb func(Resp, error)) error {
+	err := check[Resp, Req](s, id)
+	if err != nil {
+		return err
+	}
+
+	return c.AsynCall(s, id, req, func(ret interface{}, err error) {
+		var resp Resp
+		if err == nil {
+			resp, err = retOf[Resp](id, ret)
+		}
+		if cb != nil {
+			cb(resp, err)
+		}
+	})
+}
+
+// check if handler of id can take Req and return Resp. Handlers not registered by Handle are not checked.
+func check[Resp, Req any](s *Server, id interface{}) error {
+	sig := s.signatures[id]
+	if sig == nil {
+		return nil
+	}
+
+	if !typeOf[Req]().AssignableTo(sig.req) || !sig.resp.AssignableTo(typeOf[Resp]()) {
+		return fmt.Errorf("function id %v: want func(%v) %v, got func(%v) %v",
+			id, sig.req, sig.resp, typeOf[Req](), typeOf[Resp]())
+	}
+	return nil
+}
+
+// argOf get the only argument of type Req from args.
+func argOf[Req any](id interface{}, args []interface{}) (req Req, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("function id %v: want 1 argument, got %v", id, len(args))
+		return
+	}
+
+	if args[0] == nil {
+		if !nilable(typeOf[Req]()) {
+			err = fmt.Errorf("function id %v: want argument of %v, got nil", id, typeOf[Req]())
+		}
+		return
+	}
+
+	req, ok := args[0].(Req)
+	if !ok {
+		err = fmt.Errorf("function id %v: want argument of %v, got %T", id, typeOf[Req](), args[0])
+	}
+	return
+}
+
+// retOf convert ret to Resp.
+func retOf[Resp any](id interface{}, ret interface{}) (resp Resp, err error) {
+	if ret == nil {
+		return
+	}
+
+	resp, ok := ret.(Resp)
+	if !ok {
+		err = fmt.Errorf("function id %v: want return of %v, got %T", id, typeOf[Resp](), ret)
+	}
+	return
+}
+
+// nilable return if nil is a value of type t, such as pointer and interface.
+func nilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// typeOf return the reflect type of T, works for interface types too.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
@@ -0,0 +1,88 @@
+package chanrpc_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+type AddReq struct {
+	N1, N2 int
+}
+
+func TestTypedCall(t *testing.T) {
+	closesig := make(chan bool)
+
+	var wg sync.WaitGroup
+
+	s := chanrpc.NewServer(10, 1000)
+	chanrpc.Handle(s, "add", func(req AddReq) (int, error) {
+		return req.N1 + req.N2, nil
+	})
+	s.Register("untyped", func(args []interface{}) (ret interface{}, err error) {
+		return len(args), nil
+	})
+	Start(s, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+	Wait(c, closesig)
+	defer func() {
+		closesig <- true
+	}()
+
+	n, err := chanrpc.Call[int](c, s, "add", AddReq{1, 2})
+	if err != nil || n != 3 {
+		t.Errorf("expect 3, got %v %v", n, err)
+	}
+
+	// wrong type of request or response is rejected before the call is sent.
+	if _, err := chanrpc.Call[int](c, s, "add", 1); err == nil {
+		t.Error("expect error of request type")
+	}
+	if _, err := chanrpc.Call[string](c, s, "add", AddReq{1, 2}); err == nil {
+		t.Error("expect error of response type")
+	}
+
+	// untyped call to typed handler is checked at call time.
+	if _, err := c.SynCall(s, "add", 1, 2); err == nil {
+		t.Error("expect error of argument count")
+	}
+
+	// nil is not a AddReq.
+	if _, err := c.SynCall(s, "add", nil); err == nil {
+		t.Error("expect error of nil argument")
+	}
+
+	// untyped handler still works, result is converted.
+	n, err = chanrpc.Call[int](c, s, "untyped", "a")
+	if err != nil || n != 1 {
+		t.Errorf("expect 1, got %v %v", n, err)
+	}
+
+	wg.Add(1)
+	err = chanrpc.CallAsyn(c, s, "add", AddReq{3, 4}, func(n int, err error) {
+		if err != nil || n != 7 {
+			t.Errorf("expect 7, got %v %v", n, err)
+		}
+		wg.Done()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// types of replaced handlers are not checked any more.
+	s.SetHandlers(map[interface{}]interface{}{
+		"add": func(args []interface{}) (interface{}, error) {
+			return "added", nil
+		},
+	})
+	ret, err := chanrpc.Call[string](c, s, "add", AddReq{1, 2})
+	if err != nil || ret != "added" {
+		t.Errorf("expect added, got %v %v", ret, err)
+	}
+}
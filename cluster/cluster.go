@@ -0,0 +1,390 @@
+// Package cluster connect lpge nodes over TCP, so modules are called across nodes by "node:name".
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/module"
+	"github.com/LuisZhou/lpge/network"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cmds of messages between nodes.
+const (
+	cmdHello uint16 = iota + 1 // name of node, sent first by both sides.
+	cmdCall                    // call of module.
+	cmdRet                     // return of call.
+)
+
+// Codec encode messages between nodes, including ids, args and returns of calls.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)      // encode v.
+	Unmarshal(data []byte, v interface{}) error // decode data to v.
+}
+
+// gobCodec is the default codec, concrete types in ids, args and returns must be registered by Register.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// codec is the codec of all nodes.
+var codec Codec = gobCodec{}
+
+// SetCodec set codec of messages, it must be called before any node starts, and be the same of all nodes.
+func SetCodec(c Codec) {
+	codec = c
+}
+
+// Register register concrete type of value sent in ids, args or returns of calls, for the default codec. Basic types
+// need no registration.
+func Register(value interface{}) {
+	gob.Register(value)
+}
+
+// hello is the message of cmdHello.
+type hello struct {
+	Node string
+}
+
+// request is the message of cmdCall.
+type request struct {
+	Seq  uint64        // sequence of call in its connection.
+	Name string        // name of module called.
+	Id   interface{}   // id of rpc.
+	Args []interface{} // args of rpc.
+}
+
+// response is the message of cmdRet.
+type response struct {
+	Seq    uint64      // sequence of call.
+	Ret    interface{} // return of call.
+	Err    string      // error of call.
+	Failed bool        // the call returns error.
+}
+
+// Node is a node of cluster, it listens to other nodes and connects to other nodes, both can call modules of each
+// other once connected.
+type Node struct {
+	Name            string        // name of node, told to other nodes.
+	ListenAddr      string        // address listened, not listening if empty.
+	ConnAddrs       []string      // addresses of nodes connected.
+	PendingWriteNum int           // write channel buffer number, per connection.
+	ConnectInterval time.Duration // interval of reconnecting.
+	CallTimeout     time.Duration // max time of waiting for return of call, 0 is no limit.
+	server          *network.TCPServer
+	clients         []*network.TCPClient
+	peers           map[string]*agent // connected nodes by name.
+	mutex           sync.Mutex        // mutex protect peers.
+}
+
+// Default is the node of conf, started by Init.
+var Default *Node
+
+// Init start the node of conf, and make it the transport of remote calls of module. Nothing is done if neither
+// conf.ListenAddr nor conf.ConnAddrs is set.
+func Init() {
+	if conf.ListenAddr == "" && len(conf.ConnAddrs) == 0 {
+		return
+	}
+
+	Default = &Node{
+		Name:            conf.NodeName,
+		ListenAddr:      conf.ListenAddr,
+		ConnAddrs:       conf.ConnAddrs,
+		PendingWriteNum: conf.PendingWriteNum,
+		CallTimeout:     time.Duration(conf.CallTimeout) * time.Millisecond,
+	}
+	Default.Start()
+	module.SetRemote(Default)
+}
+
+// Destroy close the node of conf.
+func Destroy() {
+	if Default != nil {
+		module.SetRemote(nil)
+		Default.Close()
+	}
+}
+
+// Start start listening and connecting.
+func (n *Node) Start() {
+	n.peers = make(map[string]*agent)
+
+	if n.ListenAddr != "" {
+		n.server = &network.TCPServer{
+			Addr:            n.ListenAddr,
+			MaxConnNum:      int(math.MaxInt32),
+			PendingWriteNum: n.PendingWriteNum,
+			MaxMsgLen:       math.MaxUint16,
+			NewAgent:        n.newAgent,
+		}
+		n.server.Start()
+	}
+
+	for _, addr := range n.ConnAddrs {
+		client := &network.TCPClient{
+			Addr:            addr,
+			ConnNum:         1,
+			ConnectInterval: n.ConnectInterval,
+			PendingWriteNum: n.PendingWriteNum,
+			AutoReconnect:   true,
+			MaxMsgLen:       math.MaxUint16,
+			NewAgent:        n.newAgent,
+		}
+		client.Start()
+		n.clients = append(n.clients, client)
+	}
+}
+
+// Close close all connections, calls waiting for return get error.
+func (n *Node) Close() {
+	for _, client := range n.clients {
+		client.Close()
+	}
+	if n.server != nil {
+		n.server.Close()
+	}
+}
+
+// Peers return names of connected nodes, sorted.
+func (n *Node) Peers() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	names := make([]string, 0, len(n.peers))
+	for name := range n.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Call call rpc id of module name of node with args, and wait for its return at most CallTimeout, or ErrTimeout is
+// returned. It is goroutine safe.
+func (n *Node) Call(node string, name string, id interface{}, args ...interface{}) (interface{}, error) {
+	n.mutex.Lock()
+	a, ok := n.peers[node]
+	n.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("node %v is not connected", node)
+	}
+	return a.call(name, id, args)
+}
+
+// newAgent create agent of connection to other node.
+func (n *Node) newAgent(conn *network.TCPConn) network.Agent {
+	return &agent{
+		node:     n,
+		conn:     conn,
+		client:   chanrpc.NewClient(100, time.Second),
+		pending:  make(map[uint64]chan *response),
+		closeSig: make(chan bool),
+	}
+}
+
+// agent is the connection to other node.
+type agent struct {
+	node     *Node
+	conn     *network.TCPConn
+	peer     string                    // name of node connected, empty before hello.
+	client   *chanrpc.Client           // client of calls from the node.
+	seq      uint64                    // sequence of the last call to the node.
+	pending  map[uint64]chan *response // calls to the node waiting for return.
+	closed   bool                      // connection is closed.
+	mutex    sync.Mutex                // mutex protect seq, pending and closed.
+	closeSig chan bool                 // closed when connection is closed.
+}
+
+// errClosed is returned to calls when the connection is closed.
+var errClosed = errors.New("connection to node closed")
+
+// ErrTimeout is returned to calls not returned in CallTimeout of node.
+var ErrTimeout = errors.New("call to node timed out")
+
+func (a *agent) Run() {
+	go a.serve()
+
+	if err := a.write(cmdHello, &hello{Node: a.node.Name}); err != nil {
+		log.Error("hello to node %v: %v", a.conn.RemoteAddr(), err)
+		return
+	}
+
+	for {
+		cmd, data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message: %v", err)
+			return
+		}
+
+		switch cmd {
+		case cmdHello:
+			var h hello
+			if err := codec.Unmarshal(data, &h); err != nil {
+				log.Error("hello of node %v: %v", a.conn.RemoteAddr(), err)
+				return
+			}
+			a.hello(h.Node)
+		case cmdCall:
+			var req request
+			if err := codec.Unmarshal(data, &req); err != nil {
+				log.Error("call from node %v: %v", a.peer, err)
+				continue
+			}
+			a.serveCall(&req)
+		case cmdRet:
+			var resp response
+			if err := codec.Unmarshal(data, &resp); err != nil {
+				log.Error("return from node %v: %v", a.peer, err)
+				continue
+			}
+			a.mutex.Lock()
+			ch, ok := a.pending[resp.Seq]
+			delete(a.pending, resp.Seq)
+			a.mutex.Unlock()
+			if ok {
+				ch <- &resp
+			}
+		default:
+			log.Error("unknown message %v from node %v", cmd, a.peer)
+		}
+	}
+}
+
+func (a *agent) OnClose() {
+	close(a.closeSig)
+
+	a.node.mutex.Lock()
+	if a.peer != "" && a.node.peers[a.peer] == a {
+		delete(a.node.peers, a.peer)
+	}
+	a.node.mutex.Unlock()
+
+	a.mutex.Lock()
+	a.closed = true
+	pending := a.pending
+	a.pending = nil
+	a.mutex.Unlock()
+	for _, ch := range pending {
+		ch <- &response{Err: errClosed.Error(), Failed: true}
+	}
+}
+
+// hello register the agent as the connection to node.
+func (a *agent) hello(node string) {
+	a.peer = node
+	a.node.mutex.Lock()
+	a.node.peers[node] = a
+	a.node.mutex.Unlock()
+}
+
+// serve pass returns of calls from the node to their callbacks.
+func (a *agent) serve() {
+	for {
+		select {
+		case ri := <-a.client.ChanAsynRet:
+			a.client.Cb(ri)
+		case <-a.closeSig:
+			return
+		}
+	}
+}
+
+// serveCall call the local module of req, the return is sent back by its callback.
+func (a *agent) serveCall(req *request) {
+	m, _, err := module.Search(req.Name)
+	if err != nil {
+		a.ret(req.Seq, nil, err)
+		return
+	}
+
+	args := append(req.Args, func(ret interface{}, err error) {
+		a.ret(req.Seq, ret, err)
+	})
+	if err := a.client.AsynCall(m.GetChanrpcServer(), req.Id, args...); err != nil {
+		a.ret(req.Seq, nil, err)
+	}
+}
+
+// ret send return of call seq to the node.
+func (a *agent) ret(seq uint64, ret interface{}, err error) {
+	resp := &response{Seq: seq, Ret: ret}
+	if err != nil {
+		resp.Err, resp.Failed = err.Error(), true
+	}
+	if err := a.write(cmdRet, resp); err != nil {
+		// the return itself may fail to encode.
+		log.Error("return to node %v: %v", a.peer, err)
+		a.write(cmdRet, &response{Seq: seq, Err: err.Error(), Failed: true})
+	}
+}
+
+// call send a call to the node, and wait for its return.
+func (a *agent) call(name string, id interface{}, args []interface{}) (interface{}, error) {
+	ch := make(chan *response, 1)
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil, errClosed
+	}
+	a.seq++
+	seq := a.seq
+	a.pending[seq] = ch
+	a.mutex.Unlock()
+
+	if err := a.write(cmdCall, &request{Seq: seq, Name: name, Id: id, Args: args}); err != nil {
+		a.mutex.Lock()
+		delete(a.pending, seq)
+		a.mutex.Unlock()
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if a.node.CallTimeout > 0 {
+		t := time.NewTimer(a.node.CallTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var resp *response
+	select {
+	case resp = <-ch:
+	case <-timeout:
+		// the return coming later is dropped.
+		a.mutex.Lock()
+		delete(a.pending, seq)
+		a.mutex.Unlock()
+		return nil, ErrTimeout
+	}
+	if resp.Failed {
+		return resp.Ret, errors.New(resp.Err)
+	}
+	return resp.Ret, nil
+}
+
+// write encode message v and send it with cmd.
+func (a *agent) write(cmd uint16, v interface{}) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > math.MaxUint16 {
+		return fmt.Errorf("message of %v bytes too long", len(data))
+	}
+	return a.conn.WriteMsg(cmd, data)
+}
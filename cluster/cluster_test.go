@@ -0,0 +1,101 @@
+package cluster_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/cluster"
+	"github.com/LuisZhou/lpge/module"
+	"testing"
+	"time"
+)
+
+// point is a type sent between nodes.
+type point struct {
+	X, Y int
+}
+
+// echo is a module returning its arg.
+type echo struct {
+	*module.Skeleton
+}
+
+func newEcho() *echo {
+	m := &echo{Skeleton: &module.Skeleton{ChanRPCLen: 10, AsynCallLen: 10, GoLen: 10}}
+	m.Init()
+	m.RegisterChanRPC(uint16(1), func(args []interface{}) (interface{}, error) {
+		return args[0], nil
+	})
+	m.RegisterChanRPC(uint16(2), func(args []interface{}) (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+		return args[0], nil
+	})
+	return m
+}
+
+func (m *echo) OnInit() {
+}
+
+func (m *echo) OnDestroy() {
+}
+
+func TestRemoteCall(t *testing.T) {
+	cluster.Register(point{})
+
+	a := &cluster.Node{Name: "a", ListenAddr: "localhost:6301"}
+	a.Start()
+	defer a.Close()
+	b := &cluster.Node{Name: "b", ConnAddrs: []string{"localhost:6301"}, ConnectInterval: 10 * time.Millisecond,
+		CallTimeout: 100 * time.Millisecond}
+	b.Start()
+	defer b.Close()
+
+	for i := 0; len(b.Peers()) == 0 || len(a.Peers()) == 0; i++ {
+		if i > 100 {
+			t.Fatal("nodes not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	module.SetRemote(b)
+	defer module.SetRemote(nil)
+
+	// both nodes are in this process, so they share modules.
+	module.Register(newEcho(), "cluster-echo")
+	defer module.DestroyOne("cluster-echo")
+	module.Register(newEcho(), "cluster-caller")
+	defer module.DestroyOne("cluster-caller")
+
+	caller, _, err := module.Search("cluster-caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := caller.SynCall("a:cluster-echo", 1, point{1, 2})
+	if err != nil || ret != (point{1, 2}) {
+		t.Fatalf("unexpected return %v %v", ret, err)
+	}
+
+	done := make(chan interface{}, 1)
+	err = caller.AsynCall("a:cluster-echo", 1, "hi", func(ret interface{}, err error) {
+		done <- ret
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ret := <-done:
+		if ret != "hi" {
+			t.Errorf("unexpected return %v", ret)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("async call not returned")
+	}
+
+	if _, err := caller.SynCall("a:cluster-none", 1, "hi"); err == nil {
+		t.Error("expect error of unknown module")
+	}
+	if _, err := caller.SynCall("c:cluster-echo", 1, "hi"); err == nil {
+		t.Error("expect error of unknown node")
+	}
+	if _, err := caller.SynCall("a:cluster-echo", 2, "hi"); !errors.Is(err, cluster.ErrTimeout) {
+		t.Errorf("expect timeout, got %v", err)
+	}
+}
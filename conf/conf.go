@@ -9,6 +9,15 @@ type ModuleConfig struct {
 	TimeoutAsynRet     int
 }
 
+// RateLimitConfig is the token bucket limit of messages of client.
+type RateLimitConfig struct {
+	MsgRate   int    // messages per second, no limit if 0.
+	MsgBurst  int    // max messages at once, MsgRate if 0.
+	ByteRate  int    // bytes per second, no limit if 0.
+	ByteBurst int    // max bytes at once, ByteRate if 0.
+	Action    string // action on exceeding, "drop", "delay", "warn" or "kick", "drop" if empty.
+}
+
 type DbConfig struct {
 	Default  string
 	Mysql    string
@@ -30,10 +39,18 @@ var (
 	ConsolePrompt string = "Lpge# "
 	ProfilePath   string
 
+	// chanrpc
+	SlowHandler int // threshold in millisecond of logging slow handler, 0 to disable.
+
+	// module
+	DrainTimeout int = 5000 // max millisecond of module draining its work when destroyed, 0 is no limit.
+
 	// cluster
+	NodeName        string // name of this node, other nodes call its modules by "NodeName:name".
 	ListenAddr      string
 	ConnAddrs       []string
 	PendingWriteNum int
+	CallTimeout     int = 10000 // max millisecond of waiting for return of call to other node, 0 is no limit.
 
 	// gate config
 	GateConfig ModuleConfig
@@ -41,6 +58,10 @@ var (
 	// agent config
 	AgentConfig ModuleConfig
 
+	// rate limit of agent, and of its cmds.
+	AgentRateLimit     RateLimitConfig
+	AgentCmdRateLimits map[uint16]RateLimitConfig
+
 	// function module config
 	FunctionConfig ModuleConfig
 
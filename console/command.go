@@ -77,6 +77,45 @@ func Register(name string, help string, f func([]interface{}) (interface{}, erro
 	commands = append(commands, c)
 }
 
+// FuncCommand is the command registered by RegisterFunc, run by a function in the goroutine of console.
+type FuncCommand struct {
+	_name string                     // name of command.
+	_help string                     // help of command.
+	f     func(args []string) string // function running command, return the output.
+}
+
+// name return the name of c.
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+// help return the help of c.
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+// run run c with args, and return the output.
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// RegisterFunc register command run by f in the goroutine of console, f must be goroutine safe.
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 type CommandHelp struct{}
 
@@ -0,0 +1,205 @@
+// Package event provides a publish/subscribe bus between modules, built on chanrpc.
+//
+// Topics are segments separated by dot, such as "player.login". A pattern of subscription matches topics segment by
+// segment, "*" matches one segment, and "#" matches zero or more segments, so "player.*" matches "player.login", and
+// "#" matches every topic.
+//
+// Events are delivered by one-way calls to the chanrpc server of subscriber, so the callback runs in the goroutine of
+// the subscriber. The server must have the handler of Id, see Register.
+package event
+
+import (
+	"context"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Id is the call id of event delivery.
+var Id = id{}
+
+// id is the type of Id.
+type id struct{}
+
+// Subscription is a subscription of a pattern in bus.
+type Subscription struct {
+	bus      *Bus                                  // bus subscribed.
+	server   *chanrpc.Server                       // server of subscriber.
+	pattern  []string                              // segments of pattern.
+	f        func(topic string, event interface{}) // callback.
+	client   *chanrpc.Client                       // client delivering events, its timeout is the wait of subscription.
+	block    bool                                  // publisher blocks until the event is accepted.
+	canceled bool                                  // unsubscribed, events in flight are dropped.
+	dropped  int                                   // events dropped as the server of subscriber is busy.
+	mutex    sync.Mutex                            // mutex protect fields.
+}
+
+// Bus is a publish/subscribe bus.
+type Bus struct {
+	subs  []*Subscription // subscriptions.
+	mutex sync.RWMutex    // mutex protect subs.
+}
+
+// Default is the default bus.
+var Default = NewBus()
+
+// NewBus create a bus.
+func NewBus() *Bus {
+	return new(Bus)
+}
+
+// Register register the handler of Id to server s, so s can subscribe.
+func Register(s *chanrpc.Server) {
+	s.Register(Id, handle)
+}
+
+// handle deliver a event to the callback of subscription, in the goroutine of subscriber.
+func handle(args []interface{}) (interface{}, error) {
+	sub := args[0].(*Subscription)
+
+	sub.mutex.Lock()
+	canceled := sub.canceled
+	sub.mutex.Unlock()
+
+	if !canceled {
+		sub.f(args[1].(string), args[2])
+	}
+	return nil, nil
+}
+
+// Subscribe make f called in the goroutine of server s with every event published to topics matching pattern. By
+// default events are dropped if the server is busy, see SetWait.
+func (b *Bus) Subscribe(s *chanrpc.Server, pattern string, f func(topic string, event interface{})) *Subscription {
+	sub := &Subscription{
+		bus:     b,
+		server:  s,
+		pattern: strings.Split(pattern, "."),
+		f:       f,
+		client:  chanrpc.NewClient(0, 0),
+	}
+
+	b.mutex.Lock()
+	b.subs = append(b.subs, sub)
+	b.mutex.Unlock()
+	return sub
+}
+
+// Publish publish event to topic, it return the number of subscribers accepting it. It is goroutine safe.
+func (b *Bus) Publish(topic string, event interface{}) int {
+	segments := strings.Split(topic, ".")
+
+	b.mutex.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if match(sub.pattern, segments) {
+			subs = append(subs, sub)
+		}
+	}
+	b.mutex.RUnlock()
+
+	n := 0
+	for _, sub := range subs {
+		if sub.deliver(topic, event) {
+			n++
+		}
+	}
+	return n
+}
+
+// Subscribe subscribe pattern of Default.
+func Subscribe(s *chanrpc.Server, pattern string, f func(topic string, event interface{})) *Subscription {
+	return Default.Subscribe(s, pattern, f)
+}
+
+// Publish publish event to topic of Default.
+func Publish(topic string, event interface{}) int {
+	return Default.Publish(topic, event)
+}
+
+// SetWait set how long the publisher waits when the server of subscriber is busy, before the event is dropped. 0 drops
+// at once, the default, and a negative d blocks the publisher until the event is accepted. Blocking subscribers must
+// never publish back to their publishers with blocking, or both may wait forever.
+func (sub *Subscription) SetWait(d time.Duration) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	sub.block = d < 0
+	if d < 0 {
+		d = 0
+	}
+	sub.client = chanrpc.NewClient(0, d)
+}
+
+// Unsubscribe stop delivery of subscription, events not delivered yet are dropped.
+func (sub *Subscription) Unsubscribe() {
+	sub.mutex.Lock()
+	if sub.canceled {
+		sub.mutex.Unlock()
+		return
+	}
+	sub.canceled = true
+	sub.mutex.Unlock()
+
+	b := sub.bus
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+}
+
+// Dropped return the number of events dropped as the server of subscriber is busy.
+func (sub *Subscription) Dropped() int {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return sub.dropped
+}
+
+// deliver send event to the server of subscriber, and return if it is accepted.
+func (sub *Subscription) deliver(topic string, event interface{}) bool {
+	sub.mutex.Lock()
+	c, block, canceled := sub.client, sub.block, sub.canceled
+	sub.mutex.Unlock()
+
+	if canceled {
+		return false
+	}
+
+	var err error
+	if block {
+		err = c.CastContext(context.Background(), sub.server, Id, sub, topic, event)
+	} else {
+		err = c.Cast(sub.server, Id, sub, topic, event)
+	}
+	if err != nil {
+		sub.mutex.Lock()
+		sub.dropped++
+		sub.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// match return if topic matches pattern, both are split into segments.
+func match(pattern []string, topic []string) bool {
+	if len(pattern) == 0 {
+		return len(topic) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(topic); i++ {
+			if match(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(topic) > 0 && match(pattern[1:], topic[1:])
+	default:
+		return len(topic) > 0 && pattern[0] == topic[0] && match(pattern[1:], topic[1:])
+	}
+}
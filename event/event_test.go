@@ -0,0 +1,100 @@
+package event_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/event"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func serve(s *chanrpc.Server) {
+	for s.Depth() > 0 {
+		s.Exec(<-s.ChanCall)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := event.NewBus()
+	s := chanrpc.NewServer(10, 0)
+	event.Register(s)
+
+	var got []string
+	for _, pattern := range []string{"player.login", "player.*", "#", "player.#", "*.login.*", "mail"} {
+		pattern := pattern
+		b.Subscribe(s, pattern, func(topic string, e interface{}) {
+			got = append(got, pattern+" "+topic+" "+e.(string))
+		})
+	}
+
+	if n := b.Publish("player.login", "a"); n != 4 {
+		t.Errorf("expect 4 subscribers, got %v", n)
+	}
+	serve(s)
+
+	expect := []string{"player.login player.login a", "player.* player.login a", "# player.login a", "player.# player.login a"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expect %v, got %v", expect, got)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := event.NewBus()
+	s := chanrpc.NewServer(10, 0)
+	event.Register(s)
+
+	n := 0
+	sub := b.Subscribe(s, "tick", func(topic string, e interface{}) {
+		n++
+	})
+
+	b.Publish("tick", nil)
+	sub.Unsubscribe()
+	if b.Publish("tick", nil) != 0 {
+		t.Error("event published after unsubscribe")
+	}
+	serve(s)
+
+	// the event in flight is dropped too.
+	if n != 0 {
+		t.Errorf("expect no event, got %v", n)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	b := event.NewBus()
+	s := chanrpc.NewServer(1, 0)
+	event.Register(s)
+
+	n := 0
+	drop := b.Subscribe(s, "tick", func(topic string, e interface{}) {
+		n++
+	})
+
+	b.Publish("tick", nil)
+	if b.Publish("tick", nil) != 0 || drop.Dropped() != 1 {
+		t.Errorf("expect dropped event, got %v", drop.Dropped())
+	}
+
+	// a blocking subscriber waits for the server.
+	drop.Unsubscribe()
+	block := b.Subscribe(s, "tick", func(topic string, e interface{}) {
+		n++
+	})
+	block.SetWait(-1)
+	served := make(chan bool)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		serve(s)
+		served <- true
+	}()
+	if b.Publish("tick", nil) != 1 || block.Dropped() != 0 {
+		t.Error("expect blocked event accepted")
+	}
+	<-served
+	serve(s)
+
+	if n != 1 {
+		t.Errorf("expect 1 event, got %v", n)
+	}
+}
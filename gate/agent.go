@@ -1,6 +1,8 @@
 package gate
 
 import (
+	"context"
+	"fmt"
 	"github.com/LuisZhou/lpge/chanrpc"
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/log"
@@ -8,6 +10,7 @@ import (
 	"github.com/LuisZhou/lpge/network"
 	"net"
 	"reflect"
+	"time"
 )
 
 // Agent for client of ws or tcp.
@@ -22,14 +25,22 @@ import (
 // 	GoRpc(id interface{}, args ...interface{}) // GoRpc do a async call to this Skeleton's rpc server, but no async ret.
 // }
 
+// bulk is the context of calls made for messages of client.
+var bulk = chanrpc.WithPriority(context.Background(), chanrpc.PriorityBulk)
+
 // Implement of Agent.
 type AgentTemplate struct {
-	*module.Skeleton                   // is a Skeleton.
-	conn             network.Conn      // conn of this agent.
-	gate             *Gate             // gate of this server.
-	userData         interface{}       // user data.
-	Processor        network.Processor // processor of msg.
-	closeChan        chan bool         // close sig for internal module skeleton.
+	*module.Skeleton                     // is a Skeleton.
+	conn             network.Conn        // conn of this agent.
+	gate             *Gate               // gate of this server.
+	userData         interface{}         // user data.
+	Processor        network.Processor   // processor of msg.
+	closeChan        chan bool           // close sig for internal module skeleton.
+	authed           bool                // client is authenticated, or no authentication is needed.
+	reason           error               // reason of closing.
+	limiter          *limiter            // rate limits of messages, nil if none.
+	groups           map[*Group]struct{} // groups joined, protected by gate.mutexGroups.
+	left             bool                // agent is closed and left its groups, protected by gate.mutexGroups.
 }
 
 // Init do init agent.
@@ -37,6 +48,9 @@ func (a *AgentTemplate) Init(conn network.Conn, gate *Gate) {
 	a.conn = conn
 	a.gate = gate
 	a.closeChan = make(chan bool, 1)
+	if gate != nil {
+		a.limiter = gate.newLimiter()
+	}
 
 	s := &module.Skeleton{
 		GoLen:              conf.AgentConfig.GoLen,
@@ -51,30 +65,100 @@ func (a *AgentTemplate) Init(conn network.Conn, gate *Gate) {
 	a.Skeleton = s
 }
 
-// Run start process msg from agent. The server will go this func when new agent create.
+// Run start process msg from agent. The server will go this func when new agent create. If the gate has Authenticator,
+// the client must login within the deadline before any message is dispatched, and it is disconnected on failure.
 func (a *AgentTemplate) Run() {
+	a.reason = a.run()
+	log.Debug("agent %v closed: %v", a.conn.RemoteAddr(), a.reason)
+}
+
+// run process msg from agent, and return the reason of stopping.
+func (a *AgentTemplate) run() error {
+	if a.gate == nil || a.gate.Authenticator == nil {
+		a.authed = true
+	}
+	if !a.authed {
+		deadline := time.AfterFunc(a.gate.authTimeout(), func() {
+			log.Debug("authentication timeout of %v", a.conn.RemoteAddr())
+			a.conn.Close()
+		})
+		defer deadline.Stop()
+	}
+
 	for {
-		cmd, data, err := a.conn.ReadMsg()
+		var (
+			cmd  uint16
+			data []byte
+			err  error
+		)
+		if a.gate != nil {
+			cmd, data, err = a.gate.readMsg(a.conn)
+		} else {
+			cmd, data, err = a.conn.ReadMsg()
+		}
 		if err != nil {
-			log.Debug("read message: %v", err)
-			break
+			if !a.authed && err != ErrIdle {
+				return ErrAuth
+			}
+			return err
+		}
+
+		heartbeat := a.gate != nil && a.gate.heartbeat(cmd)
+		if a.gate != nil && !heartbeat && !a.gate.allowed(cmd, a.authed) {
+			return fmt.Errorf("message %v not allowed, authenticated: %v", cmd, a.authed)
+		}
+
+		if a.limiter != nil {
+			ok, err := a.limiter.admit(cmd, len(data))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if heartbeat {
+			a.conn.WriteMsg(cmd, nil)
+			continue
+		}
+
+		if !a.authed {
+			var msg interface{} = data
+			if a.Processor != nil {
+				if msg, err = a.Processor.Unmarshal(cmd, data); err != nil {
+					return fmt.Errorf("unmarshal message error: %v", err)
+				}
+			}
+			userData, err := a.gate.Authenticator.Authenticate(cmd, msg)
+			if err != nil {
+				log.Debug("authentication of %v error: %v", a.conn.RemoteAddr(), err)
+				return ErrAuth
+			}
+			a.SetUserData(userData)
+			a.authed = true
+			// the hook waits for the gate, so an authentication is never dropped.
+			a.gate.hook("AuthAgent", a)
+			continue
 		}
 
 		if a.Processor != nil {
 			msg, err := a.Processor.Unmarshal(cmd, data)
 			if err != nil {
-				log.Debug("unmarshal message error: %v", err)
-				break
+				return fmt.Errorf("unmarshal message error: %v", err)
 			}
-			a.GoRpc(cmd, a.UserData(), msg)
+			// messages of client go to the bulk lane, so they never delay system calls to the agent.
+			a.AsynCallContext(bulk, a.GetChanrpcServer(), cmd, a.UserData(), msg)
 		}
 	}
 }
 
-// OnClose is called when the connection is destoried.
+// OnClose is called when the connection is destoried. The agent leaves its groups, and the gate is notified by
+// "CloseAgent" with the agent and the reason of closing, such as ErrIdle.
 func (a *AgentTemplate) OnClose() {
 	if a.gate != nil {
-		_, err := chanrpc.SynCall(a.gate.Skeleton.GetChanrpcServer(), "CloseAgent", a)
+		a.gate.removeAgent(a)
+		_, err := chanrpc.SynCall(a.gate.Skeleton.GetChanrpcServer(), "CloseAgent", a, a.reason)
 		if err != nil {
 			log.Error("chanrpc error: %v", err)
 		}
@@ -0,0 +1,52 @@
+package gate
+
+import (
+	"errors"
+	"time"
+)
+
+// defaultAuthTimeout is the deadline of authentication if Gate.AuthTimeout is 0.
+const defaultAuthTimeout = 10 * time.Second
+
+// ErrAuth is the reason of closing agent not authenticated, by error or timeout.
+var ErrAuth = errors.New("authentication failed")
+
+// Authenticator authenticate client of gate by its login message.
+type Authenticator interface {
+	// Authenticate check login message msg of cmd, and return the user data of agent, or error to disconnect. It is
+	// called in the goroutine reading the connection, so it may block.
+	Authenticate(cmd uint16, msg interface{}) (userData interface{}, err error)
+}
+
+// AuthenticatorFunc is a function implementing Authenticator.
+type AuthenticatorFunc func(cmd uint16, msg interface{}) (interface{}, error)
+
+// Authenticate call f.
+func (f AuthenticatorFunc) Authenticate(cmd uint16, msg interface{}) (interface{}, error) {
+	return f(cmd, msg)
+}
+
+// authTimeout return the deadline of authentication.
+func (gate *Gate) authTimeout() time.Duration {
+	if gate.AuthTimeout > 0 {
+		return gate.AuthTimeout
+	}
+	return defaultAuthTimeout
+}
+
+// allowed return if message of cmd is allowed before or after authentication.
+func (gate *Gate) allowed(cmd uint16, authed bool) bool {
+	cmds := gate.PreAuthCmds
+	if authed {
+		cmds = gate.PostAuthCmds
+	}
+	if cmds == nil {
+		return true
+	}
+	for _, c := range cmds {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package gate_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/gate"
+	"github.com/LuisZhou/lpge/network"
+	"github.com/LuisZhou/lpge/network/processor/json"
+	"testing"
+	"time"
+)
+
+// login is the cmd of login message.
+const login = 3
+
+func newAuthed(conn network.Conn, g *gate.Gate) network.Agent {
+	a := &counter{}
+	a.Init(conn, g)
+	a.Processor = json.NewJsonProcessor()
+	a.Processor.Register(1, Msg{})
+	a.Processor.Register(login, Msg{})
+	a.RegisterChanRPC(uint16(1), func(args []interface{}) (interface{}, error) {
+		a.WriteMsg(2, &Msg{Text: args[0].(string)})
+		return nil, nil
+	})
+	return a
+}
+
+// closed expect c to be disconnected by gate.
+func (c *client) closed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if cmd, _, err := c.parser.Read(c.conn); err == nil {
+		c.t.Fatalf("expect disconnected, got message %v", cmd)
+	}
+	c.conn.Close()
+}
+
+func TestAuth(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3572",
+		LittleEndian:    true,
+		NewWsAgent:      newAuthed,
+		NewTcpAgent:     newAuthed,
+		AuthTimeout:     100 * time.Millisecond,
+		PreAuthCmds:     []uint16{login},
+		PostAuthCmds:    []uint16{1},
+		Authenticator: gate.AuthenticatorFunc(func(cmd uint16, msg interface{}) (interface{}, error) {
+			if msg.(*Msg).Text != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return "user", nil
+		}),
+	}
+	g.OnInit()
+
+	events := make(chan string, 10)
+	g.RegisterChanRPC("AuthAgent", func(args []interface{}) (interface{}, error) {
+		// a slow hook, so the ones of a burst queue up.
+		time.Sleep(5 * time.Millisecond)
+		events <- args[0].(*gate.AgentTemplate).UserData().(string)
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// message other than login before authentication.
+	c := dial(t, g.TCPAddr)
+	c.write(1, []byte(`{"Text":"a"}`))
+	c.closed()
+
+	// wrong login.
+	c = dial(t, g.TCPAddr)
+	c.write(login, []byte(`{"Text":"guess"}`))
+	c.closed()
+
+	// no login within the deadline.
+	c = dial(t, g.TCPAddr)
+	c.closed()
+
+	c = dial(t, g.TCPAddr)
+	c.write(login, []byte(`{"Text":"secret"}`))
+	expectEvent(t, events, "user")
+	c.write(1, []byte(`{"Text":"a"}`))
+	if data := c.read(2); string(data) != `{"Text":"user","Count":0}` {
+		t.Fatalf("expect user data in message, got %s", data)
+	}
+	// login is not allowed after authentication.
+	c.write(login, []byte(`{"Text":"secret"}`))
+	c.closed()
+
+	// authentications of a burst of clients are never dropped.
+	clients := make([]*client, 8)
+	for i := range clients {
+		clients[i] = dial(t, g.TCPAddr)
+		clients[i].write(login, []byte(`{"Text":"secret"}`))
+	}
+	for range clients {
+		expectEvent(t, events, "user")
+	}
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
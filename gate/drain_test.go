@@ -0,0 +1,42 @@
+package gate_test
+
+import (
+	"github.com/LuisZhou/lpge/gate"
+	"testing"
+	"time"
+)
+
+func TestDrainLeftover(t *testing.T) {
+	g := &gate.Gate{
+		NewWsAgent:  newCounter,
+		NewTcpAgent: newCounter,
+	}
+	g.OnInit()
+	g.TimeoutDrain = 50
+
+	started := make(chan bool)
+	g.RegisterChanRPC("work", func(args []interface{}) (interface{}, error) {
+		// a worker outlasting the drain timeout.
+		g.Go(func() { time.Sleep(time.Second) }, func() {})
+		started <- true
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	if err := g.GetChanrpcClient().Cast(g.GetChanrpcServer(), "work"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// Run returns once the gate is drained, with the leftover reported.
+	closeSig <- true
+	<-done
+	if g.Leftover() == nil {
+		t.Error("expect the worker left")
+	}
+}
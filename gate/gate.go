@@ -2,31 +2,64 @@
 package gate
 
 import (
+	"context"
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/log"
 	"github.com/LuisZhou/lpge/module"
 	"github.com/LuisZhou/lpge/network"
+	"sync"
 	"time"
 )
 
 // NewAgent func type, used to create new agent for one connect.
 type NewAgent func(conn network.Conn, gate *Gate) network.Agent
 
-// Gate for ws and tcp connection.
+// Gate for ws and tcp connection. Agents are told to the rpc server of gate by the ids below, each called with the
+// agent. Hooks other than "CloseAgent" are optional, the ones not registered are logged once.
+//
+//	"NewAgent"     agent is connected, or its session is started.
+//	"AuthAgent"    agent is authenticated by Authenticator, its user data is set.
+//	"SuspendAgent" connection of session is lost, the agent is kept for resuming within SessionGrace.
+//	"ResumeAgent"  session is resumed by a new connection.
+//	"CloseAgent"   agent is closed, by a sync call with the reason of closing as the second arg, such as ErrIdle,
+//	               ErrAuth, ErrRateLimit, or the error of reading if the client disconnects.
 type Gate struct {
-	*module.Skeleton               // implement of module.
-	MaxConnNum       int           // max conn of both tcp and ws connect.
-	PendingWriteNum  int           // write channel buffer number, per agent, for both tcp and ws connect.
-	MaxMsgLen        uint16        // max Msg Len of MsgParser of server, for both tcp and ws connect.
-	WSAddr           string        // websocket server address.
-	HTTPTimeout      time.Duration // websocket http timeout.
-	CertFile         string        // websocket http cert file.
-	KeyFile          string        // websocket http key file.
-	NewWsAgent       NewAgent      // websocket creator for new agent.
-	TCPAddr          string        // tcp server address.
-	LittleEndian     bool          // tcp little endian or not of tcp connection.
-	NewTcpAgent      NewAgent      // tcp creator for new agent.
-	closeChan        chan bool     // close sig for internal module skeleton.
+	*module.Skeleton                                 // implement of module.
+	MaxConnNum       int                             // max conn of both tcp and ws connect.
+	PendingWriteNum  int                             // write channel buffer number, per agent, for both tcp and ws connect.
+	MaxMsgLen        uint16                          // max Msg Len of MsgParser of server, for both tcp and ws connect.
+	WSAddr           string                          // websocket server address.
+	HTTPTimeout      time.Duration                   // websocket http timeout.
+	CertFile         string                          // websocket http cert file.
+	KeyFile          string                          // websocket http key file.
+	NewWsAgent       NewAgent                        // websocket creator for new agent.
+	TCPAddr          string                          // tcp server address.
+	LittleEndian     bool                            // tcp little endian or not of tcp connection.
+	NewTcpAgent      NewAgent                        // tcp creator for new agent.
+	SessionGrace     time.Duration                   // time a session is kept for resuming after its connection is lost, no session if 0.
+	SessionBuffer    int                             // max messages buffered per session for resuming, 256 if 0.
+	Authenticator    Authenticator                   // authenticate client by its login message before dispatching, none if nil.
+	AuthTimeout      time.Duration                   // deadline of authentication, 10 seconds if 0.
+	PreAuthCmds      []uint16                        // cmds allowed before authentication, all if nil.
+	PostAuthCmds     []uint16                        // cmds allowed after authentication, all if nil.
+	IdleTimeout      time.Duration                   // close agent receiving no message in this time, no limit if 0.
+	HeartbeatCmd     uint16                          // cmd of heartbeat of client, answered with the same cmd within rate limits and not dispatched, none if 0.
+	PingInterval     time.Duration                   // interval of websocket ping, whose pong keeps agent alive, no ping if 0.
+	RateLimit        conf.RateLimitConfig            // rate limit of messages per agent, conf.AgentRateLimit if zero.
+	CmdRateLimits    map[uint16]conf.RateLimitConfig // rate limits of messages by cmd per agent, conf.AgentCmdRateLimits if nil.
+	closeChan        chan bool                       // close sig for internal module skeleton.
+	doneChan         chan bool                       // closed when internal module skeleton is drained.
+	stopChan         chan bool                       // stop sig for servers to stop accepting connections.
+	sessions         map[string]*session             // sessions by token.
+	wgSessions       sync.WaitGroup                  // wait for agents of sessions to stop.
+	mutexSessions    sync.Mutex                      // mutex protect sessions.
+	rateStats        RateStats                       // counters of messages exceeding rate limit.
+	groups           map[string]*Group               // groups by name.
+	agents           map[*AgentTemplate]Member       // agents connected.
+	mutexGroups      sync.RWMutex                    // mutex protect groups, agents, and members of groups.
+	hooksFailed      sync.Map                        // ids of hooks not registered, logged once.
 }
 
 // Start starts ws and tcp server.
@@ -42,9 +75,10 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := gate.NewWsAgent(conn, gate)
-			gate.Skeleton.GoRpc("NewAgent", a)
-			return a
+			if gate.PingInterval > 0 {
+				conn.Ping(gate.PingInterval, gate.IdleTimeout)
+			}
+			return gate.newAgent(conn, gate.NewWsAgent)
 		}
 	}
 	log.Debug("Ws server listen on %s", gate.WSAddr)
@@ -58,9 +92,7 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := gate.NewTcpAgent(conn, gate)
-			gate.Skeleton.GoRpc("NewAgent", a)
-			return a
+			return gate.newAgent(conn, gate.NewTcpAgent)
 		}
 	}
 	log.Debug("Tcp server listen on %s", gate.TCPAddr)
@@ -73,7 +105,19 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.Start()
 	}
 
-	<-closeSig
+	select {
+	case <-gate.stopChan:
+		// stop accepting connections, and wait for close.
+		if wsServer != nil {
+			wsServer.Stop()
+		}
+		if tcpServer != nil {
+			tcpServer.Stop()
+		}
+		<-closeSig
+	case <-closeSig:
+	}
+
 	if wsServer != nil {
 		wsServer.Close()
 	}
@@ -82,7 +126,13 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.Close()
 	}
 
+	// agents of sessions, connected or waiting for resuming, stop at once.
+	gate.closeSessions()
+	gate.wgSessions.Wait()
+
+	// leftover of the skeleton is read once Run returns, after it is drained.
 	gate.closeChan <- true
+	<-gate.doneChan
 }
 
 // OnInit implement Module interface OnInit.
@@ -90,8 +140,19 @@ func (gate *Gate) OnInit() {
 	if gate.NewTcpAgent == nil || gate.NewWsAgent == nil {
 		panic("gate miss NewTcpAgent or NewWsAgent")
 	}
+	if gate.RateLimit == (conf.RateLimitConfig{}) {
+		gate.RateLimit = conf.AgentRateLimit
+	}
+	if gate.CmdRateLimits == nil {
+		gate.CmdRateLimits = conf.AgentCmdRateLimits
+	}
+	gate.checkRateLimits()
 
 	gate.closeChan = make(chan bool, 1)
+	gate.stopChan = make(chan bool, 1)
+	gate.sessions = make(map[string]*session)
+	gate.groups = make(map[string]*Group)
+	gate.agents = make(map[*AgentTemplate]Member)
 	s := &module.Skeleton{
 		GoLen:              conf.GateConfig.GoLen,
 		TimerDispatcherLen: conf.GateConfig.TimerDispatcherLen,
@@ -100,11 +161,40 @@ func (gate *Gate) OnInit() {
 		TimeoutAsynRet:     conf.GateConfig.TimeoutAsynRet,
 	}
 	s.Init()
-	go s.Run(gate.closeChan)
+	gate.doneChan = make(chan bool)
+	go func() {
+		s.Run(gate.closeChan)
+		close(gate.doneChan)
+	}()
 
 	gate.Skeleton = s
 }
 
+// OnStop implement module.Stopper, the servers stop accepting connections, while the connected agents are kept until
+// the gate is destroyed.
+func (gate *Gate) OnStop() {
+	select {
+	case gate.stopChan <- true:
+	default:
+	}
+}
+
+// hook tell the rpc server of gate agent event id with args. It waits for the server to accept it, so hooks are never
+// dropped by a burst of connections. Hooks not registered are logged once, as they are optional, other errors each time.
+func (gate *Gate) hook(id string, args ...interface{}) {
+	err := gate.Skeleton.GetChanrpcClient().CastContext(context.Background(), gate.Skeleton.GetChanrpcServer(), id, args...)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, chanrpc.ErrNotRegistered) {
+		log.Error("hook %v of gate: %v", id, err)
+		return
+	}
+	if _, failed := gate.hooksFailed.LoadOrStore(id, true); !failed {
+		log.Release("hook %v of gate: %v", id, err)
+	}
+}
+
 // OnDestroy implement Module interface OnDestroy.
 func (gate *Gate) OnDestroy() {
 
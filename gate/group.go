@@ -0,0 +1,226 @@
+package gate
+
+import (
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/network"
+	"net"
+	"reflect"
+)
+
+// Member is an agent of gate which can join groups, implemented by AgentTemplate and agents embedding it.
+type Member interface {
+	network.Agent
+	RemoteAddr() net.Addr
+	UserData() interface{}
+	template() *AgentTemplate
+}
+
+// template return a.
+func (a *AgentTemplate) template() *AgentTemplate {
+	return a
+}
+
+// Group is a set of agents of gate receiving the same messages, such as a chat room or a battle instance. Agents leave
+// their groups when closed.
+type Group struct {
+	gate    *Gate
+	name    string
+	members map[*AgentTemplate]Member // members, protected by gate.mutexGroups.
+}
+
+// NewGroup return the group of name, it is created if not exist.
+func (gate *Gate) NewGroup(name string) *Group {
+	gate.mutexGroups.Lock()
+	defer gate.mutexGroups.Unlock()
+
+	g, ok := gate.groups[name]
+	if !ok {
+		g = &Group{gate: gate, name: name, members: make(map[*AgentTemplate]Member)}
+		gate.groups[name] = g
+	}
+	return g
+}
+
+// Group return the group of name, nil if not exist.
+func (gate *Gate) Group(name string) *Group {
+	gate.mutexGroups.RLock()
+	defer gate.mutexGroups.RUnlock()
+	return gate.groups[name]
+}
+
+// RemoveGroup remove the group of name, and all agents leave it.
+func (gate *Gate) RemoveGroup(name string) {
+	gate.mutexGroups.Lock()
+	defer gate.mutexGroups.Unlock()
+
+	g, ok := gate.groups[name]
+	if !ok {
+		return
+	}
+	for a := range g.members {
+		delete(a.groups, g)
+	}
+	g.members = make(map[*AgentTemplate]Member)
+	delete(gate.groups, name)
+}
+
+// Broadcast write msg to all agents of gate.
+func (gate *Gate) Broadcast(cmd uint16, msg interface{}) {
+	gate.Multicast(cmd, msg, nil)
+}
+
+// Multicast write msg to agents of gate for which filter return true, all if filter is nil.
+func (gate *Gate) Multicast(cmd uint16, msg interface{}, filter func(m Member) bool) {
+	gate.mutexGroups.RLock()
+	members := selectMembers(gate.agents, filter)
+	gate.mutexGroups.RUnlock()
+	multicast(cmd, msg, members)
+}
+
+// addAgent add a to the agents of gate, if it is a Member.
+func (gate *Gate) addAgent(a network.Agent) {
+	m, ok := a.(Member)
+	if !ok {
+		return
+	}
+	gate.mutexGroups.Lock()
+	defer gate.mutexGroups.Unlock()
+	gate.agents[m.template()] = m
+}
+
+// removeAgent remove a from the agents and groups of gate, it can not join groups any more.
+func (gate *Gate) removeAgent(a *AgentTemplate) {
+	gate.mutexGroups.Lock()
+	defer gate.mutexGroups.Unlock()
+
+	for g := range a.groups {
+		delete(g.members, a)
+	}
+	a.groups = nil
+	a.left = true
+	delete(gate.agents, a)
+}
+
+// Name return the name of g.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Join add m to g. It does nothing if m is closed, or g is removed.
+func (g *Group) Join(m Member) {
+	g.gate.mutexGroups.Lock()
+	defer g.gate.mutexGroups.Unlock()
+
+	a := m.template()
+	if a.left || g.gate.groups[g.name] != g {
+		return
+	}
+	if a.groups == nil {
+		a.groups = make(map[*Group]struct{})
+	}
+	a.groups[g] = struct{}{}
+	g.members[a] = m
+}
+
+// Leave remove m from g.
+func (g *Group) Leave(m Member) {
+	g.gate.mutexGroups.Lock()
+	defer g.gate.mutexGroups.Unlock()
+
+	a := m.template()
+	delete(a.groups, g)
+	delete(g.members, a)
+}
+
+// Len return the number of agents in g.
+func (g *Group) Len() int {
+	g.gate.mutexGroups.RLock()
+	defer g.gate.mutexGroups.RUnlock()
+	return len(g.members)
+}
+
+// Broadcast write msg to all agents of g.
+func (g *Group) Broadcast(cmd uint16, msg interface{}) {
+	g.Multicast(cmd, msg, nil)
+}
+
+// Multicast write msg to agents of g for which filter return true, all if filter is nil.
+func (g *Group) Multicast(cmd uint16, msg interface{}, filter func(m Member) bool) {
+	g.gate.mutexGroups.RLock()
+	members := selectMembers(g.members, filter)
+	g.gate.mutexGroups.RUnlock()
+	multicast(cmd, msg, members)
+}
+
+// selectMembers return members for which filter return true, all if filter is nil. The caller should get the lock.
+func selectMembers(members map[*AgentTemplate]Member, filter func(m Member) bool) []Member {
+	selected := make([]Member, 0, len(members))
+	for _, m := range members {
+		if filter == nil || filter(m) {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+// marshaled is msg marshaled by a processor.
+type marshaled struct {
+	data []byte
+	err  error
+}
+
+// frameKey is the key of frames packed from data of processor, by conns of framing.
+type frameKey struct {
+	processor network.Processor
+	framing   interface{}
+}
+
+// multicast write msg to members. It is marshaled once per processor, and packed once per framing of conns
+// implementing network.FrameWriter, so the same frame is written to them.
+func multicast(cmd uint16, msg interface{}, members []Member) {
+	data := make(map[network.Processor]marshaled)
+	frames := make(map[frameKey][]byte)
+
+	for _, m := range members {
+		a := m.template()
+		if a.Processor == nil {
+			continue
+		}
+
+		d, ok := data[a.Processor]
+		if !ok {
+			d.data, d.err = a.Processor.Marshal(cmd, msg)
+			if d.err != nil {
+				log.Error("marshal message %v error: %v", reflect.TypeOf(msg), d.err)
+			}
+			data[a.Processor] = d
+		}
+		if d.err != nil {
+			continue
+		}
+
+		fw, ok := a.conn.(network.FrameWriter)
+		if !ok {
+			if err := a.conn.WriteMsg(cmd, d.data); err != nil {
+				log.Debug("write message %v error: %v", reflect.TypeOf(msg), err)
+			}
+			continue
+		}
+
+		key := frameKey{processor: a.Processor, framing: fw.Framing()}
+		frame, ok := frames[key]
+		if !ok {
+			var err error
+			if frame, err = fw.Pack(cmd, d.data); err != nil {
+				log.Error("pack message %v error: %v", reflect.TypeOf(msg), err)
+			}
+			frames[key] = frame
+		}
+		if frame == nil {
+			continue
+		}
+		if err := fw.WriteFrame(frame); err != nil {
+			log.Debug("write message %v error: %v", reflect.TypeOf(msg), err)
+		}
+	}
+}
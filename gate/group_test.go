@@ -0,0 +1,92 @@
+package gate_test
+
+import (
+	"github.com/LuisZhou/lpge/gate"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3575",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+	}
+	g.OnInit()
+
+	room := g.NewGroup("room")
+	events := make(chan string, 10)
+	joined := 0
+	g.RegisterChanRPC("NewAgent", func(args []interface{}) (interface{}, error) {
+		// the first two agents join the room.
+		if joined < 2 {
+			room.Join(args[0].(*counter))
+			joined++
+		}
+		events <- "NewAgent"
+		return nil, nil
+	})
+	g.RegisterChanRPC("CloseAgent", func(args []interface{}) (interface{}, error) {
+		events <- "CloseAgent"
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	clients := make([]*client, 3)
+	for i := range clients {
+		clients[i] = dial(t, g.TCPAddr)
+		expectEvent(t, events, "NewAgent")
+	}
+	if g.Group("room") != room || room.Len() != 2 {
+		t.Fatalf("expect 2 agents in room, got %v", room.Len())
+	}
+
+	room.Broadcast(2, &Msg{Text: "room"})
+	g.Broadcast(2, &Msg{Text: "all"})
+	for i, c := range clients {
+		if i < 2 {
+			if data := c.read(2); string(data) != `{"Text":"room","Count":0}` {
+				t.Fatalf("unexpected message %s", data)
+			}
+		}
+		if data := c.read(2); string(data) != `{"Text":"all","Count":0}` {
+			t.Fatalf("unexpected message %s", data)
+		}
+	}
+
+	room.Multicast(2, &Msg{Text: "filtered"}, func(m gate.Member) bool {
+		return m.RemoteAddr().String() != clients[0].conn.LocalAddr().String()
+	})
+	if data := clients[1].read(2); string(data) != `{"Text":"filtered","Count":0}` {
+		t.Fatalf("unexpected message %s", data)
+	}
+
+	// closed agents leave the room.
+	clients[0].conn.Close()
+	expectEvent(t, events, "CloseAgent")
+	if room.Len() != 1 {
+		t.Fatalf("expect 1 agent in room, got %v", room.Len())
+	}
+
+	g.RemoveGroup("room")
+	if g.Group("room") != nil || room.Len() != 0 {
+		t.Fatal("expect room removed")
+	}
+	clients[1].conn.Close()
+	clients[2].conn.Close()
+}
@@ -0,0 +1,55 @@
+package gate_test
+
+import (
+	"github.com/LuisZhou/lpge/gate"
+	"testing"
+	"time"
+)
+
+func TestHookBurst(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      50,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3576",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+	}
+	g.OnInit()
+
+	events := make(chan string, 100)
+	g.RegisterChanRPC("NewAgent", func(args []interface{}) (interface{}, error) {
+		// a slow hook, so the following ones queue up.
+		time.Sleep(5 * time.Millisecond)
+		events <- "NewAgent"
+		return nil, nil
+	})
+	g.RegisterChanRPC("CloseAgent", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// hooks of a burst of connections are never dropped.
+	clients := make([]*client, 20)
+	for i := range clients {
+		clients[i] = dial(t, g.TCPAddr)
+	}
+	for range clients {
+		expectEvent(t, events, "NewAgent")
+	}
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
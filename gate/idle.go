@@ -0,0 +1,43 @@
+package gate
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/network"
+	"net"
+	"time"
+)
+
+// ErrIdle is the reason of closing agent receiving no message within Gate.IdleTimeout.
+var ErrIdle = errors.New("idle timeout")
+
+// deadliner is implemented by connection having read deadline, such as network.TCPConn and network.WSConn.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// readMsg read message of client from conn within the idle timeout. It return ErrIdle if the client is idle.
+func (gate *Gate) readMsg(conn network.Conn) (uint16, []byte, error) {
+	if gate.IdleTimeout > 0 {
+		if d, ok := conn.(deadliner); ok {
+			d.SetReadDeadline(time.Now().Add(gate.IdleTimeout))
+		}
+	}
+
+	cmd, data, err := conn.ReadMsg()
+	if err != nil {
+		var ne net.Error
+		if gate.IdleTimeout > 0 && errors.As(err, &ne) && ne.Timeout() {
+			log.Debug("%v idle for %v", conn.RemoteAddr(), gate.IdleTimeout)
+			return 0, nil, ErrIdle
+		}
+		return 0, nil, err
+	}
+	return cmd, data, nil
+}
+
+// heartbeat return if cmd is the heartbeat of client. Heartbeats are answered by agent within its rate limit, and not
+// dispatched.
+func (gate *Gate) heartbeat(cmd uint16) bool {
+	return gate.HeartbeatCmd != 0 && cmd == gate.HeartbeatCmd
+}
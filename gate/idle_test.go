@@ -0,0 +1,67 @@
+package gate_test
+
+import (
+	"github.com/LuisZhou/lpge/gate"
+	"testing"
+	"time"
+)
+
+// heartbeat is the cmd of heartbeat.
+const heartbeat = 4
+
+func TestIdle(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3573",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+		IdleTimeout:     200 * time.Millisecond,
+		HeartbeatCmd:    heartbeat,
+	}
+	g.OnInit()
+
+	reasons := make(chan error, 10)
+	g.RegisterChanRPC("CloseAgent", func(args []interface{}) (interface{}, error) {
+		err, _ := args[1].(error)
+		reasons <- err
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// heartbeats keep the agent alive, and are not dispatched.
+	c := dial(t, g.TCPAddr)
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c.write(heartbeat, nil)
+		c.read(heartbeat)
+	}
+	c.write(1, []byte(`{"Text":"a"}`))
+	if data := c.read(2); string(data) != `{"Text":"a","Count":1}` {
+		t.Fatalf("unexpected message %s", data)
+	}
+
+	// the idle agent is closed with the reason.
+	c.closed()
+	select {
+	case err := <-reasons:
+		if err != gate.ErrIdle {
+			t.Fatalf("expect %v, got %v", gate.ErrIdle, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect CloseAgent")
+	}
+}
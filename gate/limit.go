@@ -0,0 +1,221 @@
+package gate
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// actions on message exceeding rate limit.
+const (
+	ActionDrop  = "drop"  // the message is dropped.
+	ActionDelay = "delay" // reading of client is delayed until the message is within limit.
+	ActionWarn  = "warn"  // the message is dispatched with a warning.
+	ActionKick  = "kick"  // the client is disconnected.
+)
+
+// ErrRateLimit is the reason of closing agent exceeding rate limit with ActionKick.
+var ErrRateLimit = errors.New("rate limit exceeded")
+
+// RateStats is the counters of messages exceeding rate limit, by action.
+type RateStats struct {
+	Dropped uint64
+	Delayed uint64
+	Warned  uint64
+	Kicked  uint64
+}
+
+// count add one violation of action to stats.
+func (stats *RateStats) count(action string) {
+	switch action {
+	case ActionDelay:
+		atomic.AddUint64(&stats.Delayed, 1)
+	case ActionWarn:
+		atomic.AddUint64(&stats.Warned, 1)
+	case ActionKick:
+		atomic.AddUint64(&stats.Kicked, 1)
+	default:
+		atomic.AddUint64(&stats.Dropped, 1)
+	}
+}
+
+// load return a copy of stats.
+func (stats *RateStats) load() RateStats {
+	return RateStats{
+		Dropped: atomic.LoadUint64(&stats.Dropped),
+		Delayed: atomic.LoadUint64(&stats.Delayed),
+		Warned:  atomic.LoadUint64(&stats.Warned),
+		Kicked:  atomic.LoadUint64(&stats.Kicked),
+	}
+}
+
+// bucket is a token bucket. A request larger than burst is taken when the bucket is full, leaving it in debt.
+type bucket struct {
+	rate   float64 // tokens per second.
+	burst  float64 // max tokens.
+	tokens float64
+	last   time.Time // time of last refill.
+}
+
+func newBucket(rate int, burst int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &bucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// wait return the time to wait before n tokens can be taken at now, 0 if they can be taken at once.
+func (b *bucket) wait(now time.Time, n float64) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	need := math.Min(n, b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limit is the rate limit of messages and bytes.
+type limit struct {
+	action string
+	msgs   *bucket // nil if no limit.
+	bytes  *bucket // nil if no limit.
+}
+
+func newLimit(c conf.RateLimitConfig) *limit {
+	l := &limit{action: c.Action, msgs: newBucket(c.MsgRate, c.MsgBurst), bytes: newBucket(c.ByteRate, c.ByteBurst)}
+	if l.msgs == nil && l.bytes == nil {
+		return nil
+	}
+	return l
+}
+
+// wait return the time to wait before a message of size can be taken at now, 0 if at once.
+func (l *limit) wait(now time.Time, size int) time.Duration {
+	var w time.Duration
+	if l.msgs != nil {
+		w = l.msgs.wait(now, 1)
+	}
+	if l.bytes != nil {
+		if bw := l.bytes.wait(now, float64(size)); bw > w {
+			w = bw
+		}
+	}
+	return w
+}
+
+// take take a message of size.
+func (l *limit) take(size int) {
+	if l.msgs != nil {
+		l.msgs.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(size)
+	}
+}
+
+// limiter is the rate limits of an agent, used by the goroutine reading its connection.
+type limiter struct {
+	gate  *Gate
+	all   *limit            // limit of all messages, nil if none.
+	cmds  map[uint16]*limit // limits by cmd.
+	stats RateStats
+}
+
+// newLimiter return limiter of agent, nil if no limit is configured.
+func (gate *Gate) newLimiter() *limiter {
+	l := &limiter{gate: gate, all: newLimit(gate.RateLimit), cmds: make(map[uint16]*limit)}
+	for cmd, c := range gate.CmdRateLimits {
+		if cl := newLimit(c); cl != nil {
+			l.cmds[cmd] = cl
+		}
+	}
+	if l.all == nil && len(l.cmds) == 0 {
+		return nil
+	}
+	return l
+}
+
+// admit check message of cmd and size against the limits, and return if it should be dispatched. It sleeps if the
+// message is delayed, and return ErrRateLimit if the client should be kicked.
+func (l *limiter) admit(cmd uint16, size int) (bool, error) {
+	limits := [2]*limit{l.cmds[cmd], l.all}
+	violated := false
+
+check:
+	for {
+		now := time.Now()
+		for _, lim := range limits {
+			if lim == nil {
+				continue
+			}
+			w := lim.wait(now, size)
+			if w == 0 {
+				continue
+			}
+
+			if !violated {
+				violated = true
+				l.stats.count(lim.action)
+				l.gate.rateStats.count(lim.action)
+			}
+			switch lim.action {
+			case ActionDelay:
+				time.Sleep(w)
+				continue check
+			case ActionWarn:
+				log.Release("message %v exceeds rate limit", cmd)
+				break check
+			case ActionKick:
+				return false, ErrRateLimit
+			default:
+				return false, nil
+			}
+		}
+		break
+	}
+
+	for _, lim := range limits {
+		if lim != nil {
+			lim.take(size)
+		}
+	}
+	return true, nil
+}
+
+// checkRateLimits panic if action of rate limits is unknown.
+func (gate *Gate) checkRateLimits() {
+	check := func(c conf.RateLimitConfig) {
+		switch c.Action {
+		case "", ActionDrop, ActionDelay, ActionWarn, ActionKick:
+		default:
+			panic("gate unknown rate limit action " + c.Action)
+		}
+	}
+	check(gate.RateLimit)
+	for _, c := range gate.CmdRateLimits {
+		check(c)
+	}
+}
+
+// RateStats return the counters of messages exceeding rate limit of all agents.
+func (gate *Gate) RateStats() RateStats {
+	return gate.rateStats.load()
+}
+
+// RateStats return the counters of messages exceeding rate limit of a.
+func (a *AgentTemplate) RateStats() RateStats {
+	if a.limiter == nil {
+		return RateStats{}
+	}
+	return a.limiter.stats.load()
+}
@@ -0,0 +1,115 @@
+package gate_test
+
+import (
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/gate"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	// messages admitted at once are all dispatched.
+	agentConfig := conf.AgentConfig
+	conf.AgentConfig.AsynCallLen = 10
+	conf.AgentConfig.ChanRPCLen = 10
+	defer func() {
+		conf.AgentConfig = agentConfig
+	}()
+
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3574",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+		RateLimit:       conf.RateLimitConfig{MsgRate: 2, Action: gate.ActionDrop},
+		CmdRateLimits:   map[uint16]conf.RateLimitConfig{1: {ByteRate: 100, Action: gate.ActionKick}},
+	}
+	g.OnInit()
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// messages over the burst are dropped.
+	c := dial(t, g.TCPAddr)
+	for i := 0; i < 5; i++ {
+		c.write(1, []byte(`{"Text":"a"}`))
+	}
+	time.Sleep(600 * time.Millisecond)
+	c.write(1, []byte(`{"Text":"b"}`))
+	c.read(2)
+	c.read(2)
+	if data := c.read(2); string(data) != `{"Text":"b","Count":3}` {
+		t.Fatalf("unexpected message %s", data)
+	}
+	if stats := g.RateStats(); stats.Dropped != 3 {
+		t.Fatalf("expect 3 dropped, got %+v", stats)
+	}
+	c.conn.Close()
+
+	// client exceeding bytes of cmd is kicked.
+	c = dial(t, g.TCPAddr)
+	text := []byte(`{"Text":"` + strings.Repeat("a", 50) + `"}`)
+	c.write(1, text)
+	c.read(2)
+	c.write(1, text)
+	c.closed()
+	if stats := g.RateStats(); stats.Kicked != 1 {
+		t.Fatalf("expect 1 kicked, got %+v", stats)
+	}
+}
+
+func TestHeartbeatRateLimit(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3578",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+		HeartbeatCmd:    heartbeat,
+		RateLimit:       conf.RateLimitConfig{MsgRate: 2, Action: gate.ActionDrop},
+	}
+	g.OnInit()
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// heartbeats over the burst are not answered.
+	c := dial(t, g.TCPAddr)
+	for i := 0; i < 5; i++ {
+		c.write(heartbeat, nil)
+	}
+	c.read(heartbeat)
+	c.read(heartbeat)
+	c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if cmd, _, err := c.parser.Read(c.conn); err == nil {
+		t.Fatalf("expect no more answer, got %v", cmd)
+	}
+	if stats := g.RateStats(); stats.Dropped != 3 {
+		t.Fatalf("expect 3 dropped, got %+v", stats)
+	}
+	c.conn.Close()
+}
@@ -0,0 +1,353 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/network"
+	"net"
+	"sync"
+	"time"
+)
+
+// cmds of session, reserved when sessions are enabled. Numbers of messages are big endian uint64.
+const (
+	CmdSession uint16 = 0xFFF0 + iota // server to client, the token of new session, sent before any other message.
+	CmdResume                         // client to server, the first message resuming session, number of messages received and then the token.
+	CmdResumed                        // server to client, session is resumed, number of messages sent, missed ones follow.
+	CmdAck                            // client to server, number of messages received, so they are not buffered any more.
+)
+
+// defaultSessionBuffer is the max messages buffered for resuming if Gate.SessionBuffer is 0.
+const defaultSessionBuffer = 256
+
+// errSessionClosed is returned by reading session closed or expired.
+var errSessionClosed = errors.New("session closed")
+
+// session is the connection of a client kept across reconnections. It is a network.Conn, so the agent keeps running
+// with its Skeleton and user data when the client is disconnected, until the client resumes the session, or the grace
+// period ends. Messages written are numbered and buffered until acknowledged, a client resuming gets the ones it missed.
+type session struct {
+	gate     *Gate
+	token    string
+	agent    network.Agent // agent of session.
+	first    []byte        // first message, read by handshake.
+	firstCmd uint16        // cmd of first message.
+	conn     network.Conn  // current connection, nil if detached.
+	last     network.Conn  // the last connection, for address.
+	done     chan struct{} // closed when conn is detached.
+	sent     uint64        // number of messages written.
+	buffer   []pushed      // messages not acknowledged, the last one is the sent-th.
+	timer    *time.Timer   // timer of grace period, when detached.
+	detached uint64        // times of detaching, so a stopped timer never expires a later detaching.
+	closed   bool          // session is closed or expired.
+	mutex    sync.Mutex    // mutex protect all above.
+	cond     *sync.Cond    // cond of attaching and closing.
+}
+
+// pushed is a message written to session.
+type pushed struct {
+	cmd  uint16
+	data []byte
+}
+
+// newSession create a session attached to conn, and send its token.
+func (gate *Gate) newSession(conn network.Conn) *session {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	s := &session{gate: gate, token: hex.EncodeToString(b), conn: conn, last: conn, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mutex)
+
+	gate.mutexSessions.Lock()
+	gate.sessions[s.token] = s
+	gate.mutexSessions.Unlock()
+
+	conn.WriteMsg(CmdSession, []byte(s.token))
+	return s
+}
+
+// resume attach conn to session of token, with n messages received by client. It return the channel closed when conn
+// is detached, nil if the session can not be resumed.
+func (gate *Gate) resume(token string, conn network.Conn, n uint64) <-chan struct{} {
+	gate.mutexSessions.Lock()
+	s, ok := gate.sessions[token]
+	gate.mutexSessions.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.attach(conn, n)
+}
+
+// closeSessions close all sessions.
+func (gate *Gate) closeSessions() {
+	gate.mutexSessions.Lock()
+	sessions := make([]*session, 0, len(gate.sessions))
+	for _, s := range gate.sessions {
+		sessions = append(sessions, s)
+	}
+	gate.mutexSessions.Unlock()
+
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+// attach attach conn to s, the old connection is closed, and messages after the n-th are sent again.
+func (s *session) attach(conn network.Conn, n uint64) <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	oldest := s.sent - uint64(len(s.buffer))
+	if s.closed || n < oldest || n > s.sent {
+		return nil
+	}
+
+	suspended := s.conn == nil
+	if s.conn != nil {
+		s.conn.Close()
+		close(s.done)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.conn, s.last, s.done = conn, conn, make(chan struct{})
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, s.sent)
+	conn.WriteMsg(CmdResumed, b)
+	for _, m := range s.buffer[n-oldest:] {
+		conn.WriteMsg(m.cmd, m.data)
+	}
+	s.cond.Broadcast()
+
+	if suspended {
+		s.gate.hook("ResumeAgent", s.agent)
+	}
+	return s.done
+}
+
+// detach detach conn from s if it is current, and start the grace period.
+func (s *session) detach(conn network.Conn) {
+	s.mutex.Lock()
+	if s.conn != conn || s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.conn = nil
+	close(s.done)
+	s.detached++
+	detached := s.detached
+	s.timer = time.AfterFunc(s.gate.SessionGrace, func() {
+		s.expire(detached)
+	})
+	agent := s.agent
+	s.mutex.Unlock()
+
+	s.gate.hook("SuspendAgent", agent)
+}
+
+// expire close s if it is still in the detached-th detaching.
+func (s *session) expire(detached uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil && s.detached == detached {
+		s.close()
+	}
+}
+
+// ack drop messages acknowledged by client.
+func (s *session) ack(data []byte) {
+	if len(data) != 8 {
+		return
+	}
+	n := binary.BigEndian.Uint64(data)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	oldest := s.sent - uint64(len(s.buffer))
+	if n > oldest && n <= s.sent {
+		s.buffer = s.buffer[n-oldest:]
+	}
+}
+
+// ReadMsg read message from the current connection, it waits for the client to resume when disconnected. Messages of
+// session are handled here.
+func (s *session) ReadMsg() (uint16, []byte, error) {
+	s.mutex.Lock()
+	if s.first != nil {
+		cmd, data := s.firstCmd, s.first
+		s.first = nil
+		s.mutex.Unlock()
+		return cmd, data, nil
+	}
+	s.mutex.Unlock()
+
+	for {
+		s.mutex.Lock()
+		for s.conn == nil && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mutex.Unlock()
+			return 0, nil, errSessionClosed
+		}
+		conn := s.conn
+		s.mutex.Unlock()
+
+		cmd, data, err := s.gate.readMsg(conn)
+		if err != nil {
+			log.Debug("session %v disconnected: %v", s.token, err)
+			s.detach(conn)
+			continue
+		}
+		if cmd == CmdAck {
+			s.ack(data)
+			continue
+		}
+		return cmd, data, nil
+	}
+}
+
+// WriteMsg number and buffer message, and write it if connected. Answers of heartbeat are written only, they are never
+// sent again.
+func (s *session) WriteMsg(cmd uint16, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return errSessionClosed
+	}
+	if s.gate.heartbeat(cmd) {
+		if s.conn != nil {
+			return s.conn.WriteMsg(cmd, data)
+		}
+		return nil
+	}
+	s.sent++
+	s.buffer = append(s.buffer, pushed{cmd: cmd, data: data})
+	if max := s.gate.sessionBuffer(); len(s.buffer) > max {
+		s.buffer = s.buffer[len(s.buffer)-max:]
+	}
+	if s.conn != nil {
+		return s.conn.WriteMsg(cmd, data)
+	}
+	return nil
+}
+
+// LocalAddr return the local address of the last connection.
+func (s *session) LocalAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.last.LocalAddr()
+}
+
+// RemoteAddr return the remote address of the last connection.
+func (s *session) RemoteAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.last.RemoteAddr()
+}
+
+// Close close s and its connection, the agent stops running.
+func (s *session) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.close()
+}
+
+// close close s, the caller should get the lock.
+func (s *session) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+		close(s.done)
+		s.conn = nil
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.cond.Broadcast()
+
+	s.gate.mutexSessions.Lock()
+	delete(s.gate.sessions, s.token)
+	s.gate.mutexSessions.Unlock()
+}
+
+// sessionBuffer return the max messages buffered by session.
+func (gate *Gate) sessionBuffer() int {
+	if gate.SessionBuffer > 0 {
+		return gate.SessionBuffer
+	}
+	return defaultSessionBuffer
+}
+
+// newAgent return the agent of conn, created by newAgent. If sessions are enabled, the agent is created by the first
+// message of client, unless it resumes a session.
+func (gate *Gate) newAgent(conn network.Conn, newAgent NewAgent) network.Agent {
+	if gate.SessionGrace <= 0 {
+		a := newAgent(conn, gate)
+		gate.addAgent(a)
+		gate.hook("NewAgent", a)
+		return a
+	}
+	return &handshake{gate: gate, conn: conn, newAgent: newAgent}
+}
+
+// handshake is the agent of connection with sessions enabled, it starts or resumes session by the first message. It
+// returns once the connection is detached from the session, so the connection is released while the agent of session
+// keeps running in its own goroutine.
+type handshake struct {
+	gate     *Gate
+	conn     network.Conn
+	newAgent NewAgent
+}
+
+func (h *handshake) Run() {
+	cmd, data, err := h.gate.readMsg(h.conn)
+	if err != nil {
+		log.Debug("read message: %v", err)
+		return
+	}
+
+	if cmd == CmdResume && len(data) > 8 {
+		if done := h.gate.resume(string(data[8:]), h.conn, binary.BigEndian.Uint64(data[:8])); done != nil {
+			// the agent of session reads the connection until it is detached.
+			<-done
+			return
+		}
+	}
+
+	s := h.gate.newSession(h.conn)
+	if cmd != CmdResume && cmd != CmdAck && !h.gate.heartbeat(cmd) {
+		s.first, s.firstCmd = data, cmd
+	}
+	agent := h.newAgent(s, h.gate)
+	s.mutex.Lock()
+	s.agent = agent
+	done := s.done
+	s.mutex.Unlock()
+	h.gate.addAgent(agent)
+	h.gate.hook("NewAgent", agent)
+
+	h.gate.wgSessions.Add(1)
+	go func() {
+		defer h.gate.wgSessions.Done()
+		agent.Run()
+		s.Close()
+		agent.OnClose()
+	}()
+	<-done
+}
+
+// OnClose do nothing, the agent of session is closed by its own goroutine.
+func (h *handshake) OnClose() {
+}
@@ -0,0 +1,224 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"github.com/LuisZhou/lpge/gate"
+	"github.com/LuisZhou/lpge/network"
+	"github.com/LuisZhou/lpge/network/processor/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// Msg is the message of test.
+type Msg struct {
+	Text  string
+	Count int
+}
+
+// counter is an agent counting messages of client, its count is kept across reconnections.
+type counter struct {
+	gate.AgentTemplate
+	count int
+}
+
+func newCounter(conn network.Conn, g *gate.Gate) network.Agent {
+	a := &counter{}
+	a.Init(conn, g)
+	a.Processor = json.NewJsonProcessor()
+	a.Processor.Register(1, Msg{})
+	a.RegisterChanRPC(uint16(1), func(args []interface{}) (interface{}, error) {
+		a.count++
+		a.WriteMsg(2, &Msg{Text: args[1].(*Msg).Text, Count: a.count})
+		return nil, nil
+	})
+	return a
+}
+
+// client is a raw client of gate.
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	parser *network.MsgParser
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := network.NewMsgParser()
+	parser.SetByteOrder(true)
+	return &client{t: t, conn: conn, parser: parser}
+}
+
+func (c *client) write(cmd uint16, data []byte) {
+	if err := c.parser.Write(c.conn, cmd, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read(cmd uint16) []byte {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	got, data, err := c.parser.Read(c.conn)
+	if err != nil {
+		c.t.Fatalf("expect message %v: %v", cmd, err)
+	}
+	if got != cmd {
+		c.t.Fatalf("expect message %v, got %v %s", cmd, got, data)
+	}
+	return data
+}
+
+func expectEvent(t *testing.T, events chan string, e string) {
+	t.Helper()
+	select {
+	case got := <-events:
+		if got != e {
+			t.Fatalf("expect %v, got %v", e, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect %v, got nothing", e)
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3571",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+		SessionGrace:    100 * time.Millisecond,
+	}
+	g.OnInit()
+
+	events := make(chan string, 10)
+	for _, id := range []string{"NewAgent", "SuspendAgent", "ResumeAgent", "CloseAgent"} {
+		id := id
+		g.RegisterChanRPC(id, func(args []interface{}) (interface{}, error) {
+			if id == "SuspendAgent" {
+				// pushed when the client is disconnected.
+				args[0].(*counter).WriteMsg(2, &Msg{Text: "missed"})
+			}
+			events <- id
+			return nil, nil
+		})
+	}
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	c := dial(t, g.TCPAddr)
+	c.write(1, []byte(`{"Text":"a"}`))
+	token := c.read(gate.CmdSession)
+	expectEvent(t, events, "NewAgent")
+	c.read(2)
+	c.conn.Close()
+	expectEvent(t, events, "SuspendAgent")
+
+	// the client got 1 message, and resumes for the missed one.
+	c = dial(t, g.TCPAddr)
+	resume := make([]byte, 8)
+	binary.BigEndian.PutUint64(resume, 1)
+	c.write(gate.CmdResume, append(resume, token...))
+	if n := binary.BigEndian.Uint64(c.read(gate.CmdResumed)); n != 2 {
+		t.Fatalf("expect 2 messages sent, got %v", n)
+	}
+	if data := c.read(2); string(data) != `{"Text":"missed","Count":0}` {
+		t.Fatalf("unexpected missed message %s", data)
+	}
+	expectEvent(t, events, "ResumeAgent")
+
+	// the agent is the same one.
+	c.write(1, []byte(`{"Text":"b"}`))
+	if data := c.read(2); string(data) != `{"Text":"b","Count":2}` {
+		t.Fatalf("unexpected message %s", data)
+	}
+
+	// the session expires after the grace period.
+	c.conn.Close()
+	expectEvent(t, events, "SuspendAgent")
+	expectEvent(t, events, "CloseAgent")
+
+	c = dial(t, g.TCPAddr)
+	c.write(gate.CmdResume, append(resume, token...))
+	if data := c.read(gate.CmdSession); string(data) == string(token) {
+		t.Fatal("expect new session")
+	}
+	c.conn.Close()
+}
+
+func TestSessionConnSlot(t *testing.T) {
+	// a session uses the slot of its current connection only.
+	g := &gate.Gate{
+		MaxConnNum:      1,
+		PendingWriteNum: 20,
+		MaxMsgLen:       4096,
+		TCPAddr:         "127.0.0.1:3577",
+		LittleEndian:    true,
+		NewWsAgent:      newCounter,
+		NewTcpAgent:     newCounter,
+		SessionGrace:    time.Second,
+	}
+	g.OnInit()
+
+	events := make(chan string, 10)
+	for _, id := range []string{"NewAgent", "SuspendAgent", "ResumeAgent", "CloseAgent"} {
+		id := id
+		g.RegisterChanRPC(id, func(args []interface{}) (interface{}, error) {
+			events <- id
+			return nil, nil
+		})
+	}
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		g.Run(closeSig)
+		done <- true
+	}()
+	defer func() {
+		closeSig <- true
+		<-done
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	c := dial(t, g.TCPAddr)
+	c.write(1, []byte(`{"Text":"a"}`))
+	token := c.read(gate.CmdSession)
+	expectEvent(t, events, "NewAgent")
+	c.read(2)
+
+	resume := make([]byte, 8)
+	binary.BigEndian.PutUint64(resume, 1)
+	for i := 0; i < 3; i++ {
+		c.conn.Close()
+		expectEvent(t, events, "SuspendAgent")
+		// the server goroutine of the connection is done right after detaching.
+		time.Sleep(50 * time.Millisecond)
+
+		c = dial(t, g.TCPAddr)
+		c.write(gate.CmdResume, append(resume, token...))
+		c.read(gate.CmdResumed)
+		expectEvent(t, events, "ResumeAgent")
+	}
+
+	c.write(1, []byte(`{"Text":"b"}`))
+	if data := c.read(2); string(data) != `{"Text":"b","Count":2}` {
+		t.Fatalf("unexpected message %s", data)
+	}
+	c.conn.Close()
+}
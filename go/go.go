@@ -8,12 +8,13 @@ import (
 	"github.com/LuisZhou/lpge/log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // Go is a type that provides linear execute call of function.
 type Go struct {
 	ChanCb    chan func()
-	pendingGo int
+	pendingGo int64 // number of cb not executed, read by other goroutines.
 }
 
 // New create a go instance.
@@ -25,7 +26,7 @@ func New(l int) *Go {
 
 // Go to execute f, and linear call cb. No routine-protect for execute of f. each f execute in seperate goroutine.
 func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++
+	atomic.AddInt64(&g.pendingGo, 1)
 
 	go func() {
 		defer func() {
@@ -50,7 +51,7 @@ func (g *Go) Go(f func(), cb func()) {
 // Cb execute cb.
 func (g *Go) Cb(cb func()) {
 	defer func() {
-		g.pendingGo--
+		atomic.AddInt64(&g.pendingGo, -1)
 		if r := recover(); r != nil {
 			var err error
 			if conf.LenStackBuf > 0 {
@@ -71,14 +72,19 @@ func (g *Go) Cb(cb func()) {
 
 // Close execute all pending cb in g.
 func (g *Go) Close() {
-	for g.pendingGo > 0 {
+	for atomic.LoadInt64(&g.pendingGo) > 0 {
 		g.Cb(<-g.ChanCb)
 	}
 }
 
+// Pending return the number of cb not executed yet, it is goroutine safe.
+func (g *Go) Pending() int {
+	return int(atomic.LoadInt64(&g.pendingGo))
+}
+
 // Idle return if g is idle.
 func (g *Go) Idle() bool {
-	return g.pendingGo == 0
+	return atomic.LoadInt64(&g.pendingGo) == 0
 }
 
 // LinearGo is struct composes f and cb.
@@ -105,7 +111,7 @@ func (g *Go) NewLinearContext() *LinearContext {
 
 // Go start execute linearGo in list of LinearContext.
 func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++
+	atomic.AddInt64(&c.g.pendingGo, 1)
 
 	c.mutexLinearGo.Lock()
 	c.linearGo.PushBack(&LinearGo{f: f, cb: cb})
@@ -1,6 +1,7 @@
 package lpge
 
 import (
+	"github.com/LuisZhou/lpge/cluster"
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/console"
 	"github.com/LuisZhou/lpge/log"
@@ -9,6 +10,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 func Migrate(f func(*gorm.DB)) {
@@ -24,7 +26,9 @@ func Migrate(f func(*gorm.DB)) {
 	}
 }
 
-func Run(mods map[string]module.Module) {
+// Run register mods and run them until SIGINT or SIGTERM, then shut them down in stages, and return the exit code, 0 if
+// all modules stopped cleanly, or 1.
+func Run(mods map[string]module.Module) int {
 	// logger
 	if conf.LogLevel != "" {
 		logger, err := log.New(conf.LogLevel, conf.LogPath, conf.LogFlag)
@@ -38,22 +42,29 @@ func Run(mods map[string]module.Module) {
 	log.Release("LPGE %v starting up", 1.0)
 
 	// module
-	for k, v := range mods {
-		module.Register(v, k)
+	if err := module.RegisterAll(mods); err != nil {
+		log.Error("LPGE failed to register modules: %v", err)
+		module.Destroy()
+		return 1
 	}
 
 	// cluster
-	//cluster.Init()
+	cluster.Init()
 
 	// console
+	module.RegisterCommands()
 	console.Init()
 
 	// close
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	sig := <-c
 	log.Release("LPGE closing down (signal: %v)", sig)
 	console.Destroy()
-	//cluster.Destroy()
-	module.Destroy()
+	cluster.Destroy()
+	if err := module.Shutdown(); err != nil {
+		log.Error("LPGE closed down with work left: %v", err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,154 @@
+package module
+
+import (
+	"fmt"
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/timer"
+	"time"
+)
+
+// Actor is a child actor of Skeleton, addressed by its key, such as a player or a room. Actors run in the goroutine of
+// Run of their Skeleton, so they need no lock, and cost no channel or goroutine.
+type Actor interface {
+	Restore(key interface{}) error // restore state when activated, the actor is not activated if it returns error.
+	Persist() error                // persist state before passivated, the actor is kept active if it returns error.
+}
+
+// Actors is the actors of one kind of Skeleton, spawned on demand by key. Messages are routed to actors by calling the
+// rpc server of Skeleton with the id of Actors, and args of the key of actor, the id of message and its args. An actor
+// not called for the idle time is passivated, and activated again by the next message.
+type Actors struct {
+	id       interface{}                                                            // rpc id of Actors.
+	idle     time.Duration                                                          // passivate actors idle longer, never if not positive.
+	s        *Skeleton                                                              // Skeleton running actors.
+	spawn    func(key interface{}) Actor                                            // create actor of key.
+	handlers map[interface{}]func(a Actor, args []interface{}) (interface{}, error) // handlers of messages.
+	active   map[interface{}]*activeActor                                           // active actors by key.
+}
+
+// activeActor is an active actor and its passivation timer.
+type activeActor struct {
+	Actor
+	timer *timer.Timer // timer passivating the actor, nil if actors are never passivated.
+}
+
+// NewActors create Actors of s routed by rpc id, spawn create the actor of key, which is then restored. Actors idle
+// longer than idle are passivated, never if idle is not positive. Actors left are passivated when Run stops.
+func (s *Skeleton) NewActors(id interface{}, idle time.Duration, spawn func(key interface{}) Actor) *Actors {
+	as := &Actors{
+		id:       id,
+		idle:     idle,
+		s:        s,
+		spawn:    spawn,
+		handlers: make(map[interface{}]func(Actor, []interface{}) (interface{}, error)),
+		active:   make(map[interface{}]*activeActor),
+	}
+	s.server.Register(id, as.route)
+	s.actors = append(s.actors, as)
+	return as
+}
+
+// Register register handler of message id of actors, it is called with the actor and args of message.
+func (as *Actors) Register(id interface{}, f func(a Actor, args []interface{}) (interface{}, error)) {
+	if _, ok := as.handlers[id]; ok {
+		panic(fmt.Sprintf("message %v of actors %v is already registered", id, as.id))
+	}
+	as.handlers[id] = f
+}
+
+// route route a call to the actor of args[0], with the message of args[1].
+func (as *Actors) route(args []interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("actors %v: call need key and message", as.id)
+	}
+	f, ok := as.handlers[args[1]]
+	if !ok {
+		return nil, fmt.Errorf("actors %v: message %v not registered", as.id, args[1])
+	}
+	a, err := as.Get(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return f(a, args[2:])
+}
+
+// Get return the actor of key, it is activated if not active. A message to the actor is not needed to get it, but it
+// keeps the actor active too. It must be called in the goroutine of Run.
+func (as *Actors) Get(key interface{}) (Actor, error) {
+	a, ok := as.active[key]
+	if !ok {
+		a = &activeActor{Actor: as.spawn(key)}
+		if err := a.Restore(key); err != nil {
+			return nil, fmt.Errorf("actors %v: restore %v: %v", as.id, key, err)
+		}
+		as.active[key] = a
+	}
+
+	if as.idle > 0 {
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+		a.timer = as.s.AfterFunc(as.idle, func() {
+			as.expire(key, a)
+		})
+	}
+	return a.Actor, nil
+}
+
+// expire passivate actor a of key which is idle.
+func (as *Actors) expire(key interface{}, a *activeActor) {
+	if as.active[key] != a {
+		return
+	}
+	if err := as.passivate(key, a); err != nil {
+		log.Error("%v", err)
+		// try again after another idle.
+		a.timer = as.s.AfterFunc(as.idle, func() {
+			as.expire(key, a)
+		})
+	}
+}
+
+// Passivate persist the actor of key and remove it, it does nothing if the actor is not active. It must be called in
+// the goroutine of Run.
+func (as *Actors) Passivate(key interface{}) error {
+	a, ok := as.active[key]
+	if !ok {
+		return nil
+	}
+	return as.passivate(key, a)
+}
+
+// passivate persist actor a of key and remove it.
+func (as *Actors) passivate(key interface{}, a *activeActor) error {
+	if err := a.Persist(); err != nil {
+		return fmt.Errorf("actors %v: persist %v: %v", as.id, key, err)
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	delete(as.active, key)
+	return nil
+}
+
+// passivateAll passivate all actors, actors failing to persist are dropped.
+func (as *Actors) passivateAll() {
+	for key, a := range as.active {
+		if err := as.passivate(key, a); err != nil {
+			log.Error("%v", err)
+			delete(as.active, key)
+		}
+	}
+}
+
+// Active return the number of active actors. It must be called in the goroutine of Run.
+func (as *Actors) Active() int {
+	return len(as.active)
+}
+
+// passivateActors passivate actors of all Actors of s.
+func (s *Skeleton) passivateActors() {
+	for _, as := range s.actors {
+		as.passivateAll()
+	}
+}
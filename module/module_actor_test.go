@@ -0,0 +1,102 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/module"
+	"sync"
+	"testing"
+	"time"
+)
+
+// store is the persisted state of players.
+type store struct {
+	sync.Mutex
+	scores   map[interface{}]int
+	restores int
+}
+
+func (s *store) load(key interface{}) int {
+	s.Lock()
+	defer s.Unlock()
+	s.restores++
+	return s.scores[key]
+}
+
+func (s *store) save(key interface{}, score int) {
+	s.Lock()
+	defer s.Unlock()
+	s.scores[key] = score
+}
+
+// player is an actor counting its score.
+type player struct {
+	key   interface{}
+	score int
+	store *store
+}
+
+func (p *player) Restore(key interface{}) error {
+	p.key = key
+	p.score = p.store.load(key)
+	return nil
+}
+
+func (p *player) Persist() error {
+	p.store.save(p.key, p.score)
+	return nil
+}
+
+func TestActors(t *testing.T) {
+	st := &store{scores: make(map[interface{}]int)}
+	s := &module.Skeleton{ChanRPCLen: 10, TimerDispatcherLen: 10}
+	s.Init()
+	players := s.NewActors("player", 50*time.Millisecond, func(key interface{}) module.Actor {
+		return &player{store: st}
+	})
+	players.Register("score", func(a module.Actor, args []interface{}) (interface{}, error) {
+		p := a.(*player)
+		p.score += args[0].(int)
+		return p.score, nil
+	})
+	s.RegisterChanRPC("active", func(args []interface{}) (interface{}, error) {
+		return players.Active(), nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		s.Run(closeSig)
+		done <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+	c.SynCall(s.GetChanrpcServer(), "player", "a", "score", 1)
+	c.SynCall(s.GetChanrpcServer(), "player", "b", "score", 10)
+	if ret, _ := c.SynCall(s.GetChanrpcServer(), "player", "a", "score", 2); ret != 3 {
+		t.Errorf("expect score 3, got %v", ret)
+	}
+	if ret, _ := c.SynCall(s.GetChanrpcServer(), "active"); ret != 2 {
+		t.Errorf("expect 2 active actors, got %v", ret)
+	}
+	if _, err := c.SynCall(s.GetChanrpcServer(), "player", "a", "none"); err == nil {
+		t.Error("expect error of unknown message")
+	}
+
+	// idle actors are passivated, and restored by the next message.
+	time.Sleep(150 * time.Millisecond)
+	if ret, _ := c.SynCall(s.GetChanrpcServer(), "active"); ret != 0 {
+		t.Errorf("expect actors passivated, got %v active", ret)
+	}
+	if ret, _ := c.SynCall(s.GetChanrpcServer(), "player", "a", "score", 4); ret != 7 {
+		t.Errorf("expect score 7 restored, got %v", ret)
+	}
+
+	closeSig <- true
+	<-done
+
+	st.Lock()
+	defer st.Unlock()
+	if st.scores["a"] != 7 || st.scores["b"] != 10 || st.restores != 3 {
+		t.Errorf("unexpected store %+v", st.scores)
+	}
+}
@@ -0,0 +1,96 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/module"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dependent is a module recording the order of init and destroy.
+type dependent struct {
+	*module.Skeleton
+	name    string
+	depends []string
+	trace   *[]string
+}
+
+func newDependent(name string, trace *[]string, depends ...string) *dependent {
+	s := &module.Skeleton{}
+	s.Init()
+	return &dependent{Skeleton: s, name: name, depends: depends, trace: trace}
+}
+
+func (m *dependent) OnInit() {
+	*m.trace = append(*m.trace, "init "+m.name)
+}
+
+func (m *dependent) OnDestroy() {
+	*m.trace = append(*m.trace, "destroy "+m.name)
+}
+
+func (m *dependent) Depends() []string {
+	return m.depends
+}
+
+func TestRegisterAll(t *testing.T) {
+	var trace []string
+	err := module.RegisterAll(map[string]module.Module{
+		"dep-gate": newDependent("gate", &trace, "dep-game"),
+		"dep-game": newDependent("game", &trace, "dep-db"),
+		"dep-db":   newDependent("db", &trace),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	module.Destroy()
+
+	expect := []string{"init db", "init game", "init gate", "destroy gate", "destroy game", "destroy db"}
+	if !reflect.DeepEqual(trace, expect) {
+		t.Errorf("expect %v, got %v", expect, trace)
+	}
+}
+
+func TestDestroyDepended(t *testing.T) {
+	var trace []string
+	module.Register(newDependent("db", &trace), "depended-db")
+	module.Register(newDependent("game", &trace, "depended-db"), "depended-game")
+
+	if err := module.DestroyOne("depended-db"); err == nil || !strings.Contains(err.Error(), "depended-game") {
+		t.Errorf("expect depended error, got %v", err)
+	}
+	if err := module.DestroyOne("depended-game"); err != nil {
+		t.Fatal(err)
+	}
+	if err := module.DestroyOne("depended-db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := module.DestroyOne("depended-db"); err == nil {
+		t.Error("expect error of module not registered")
+	}
+}
+
+func TestRegisterAllError(t *testing.T) {
+	var trace []string
+	err := module.RegisterAll(map[string]module.Module{
+		"cyc-a": newDependent("a", &trace, "cyc-b"),
+		"cyc-b": newDependent("b", &trace, "cyc-a"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Errorf("expect cyclic error, got %v", err)
+	}
+
+	err = module.RegisterAll(map[string]module.Module{
+		"miss-a": newDependent("a", &trace, "miss-none"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "miss-none") {
+		t.Errorf("expect missing error, got %v", err)
+	}
+
+	if err := module.Register(newDependent("a", &trace, "miss-none"), "miss-b"); err == nil {
+		t.Error("expect missing error")
+	}
+	if len(trace) != 0 {
+		t.Errorf("expect no module registered, got %v", trace)
+	}
+}
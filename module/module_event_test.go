@@ -0,0 +1,50 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/event"
+	"github.com/LuisZhou/lpge/module"
+	"testing"
+	"time"
+)
+
+func TestSkeletonEvent(t *testing.T) {
+	bus := event.NewBus()
+	got := make(chan string, 10)
+
+	s := &module.Skeleton{ChanRPCLen: 10, Bus: bus}
+	s.Init()
+	// replacing handlers keeps the handler of event delivery, without changing the map given.
+	handlers := map[interface{}]interface{}{}
+	s.SetChanRPCHandlers(handlers)
+	if len(handlers) != 0 {
+		t.Errorf("handlers given are changed: %v", handlers)
+	}
+	s.Subscribe("player.*", func(topic string, e interface{}) {
+		got <- topic
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		s.Run(closeSig)
+		done <- true
+	}()
+
+	if bus.Publish("player.login", 1) != 1 {
+		t.Fatal("event not accepted")
+	}
+	select {
+	case topic := <-got:
+		if topic != "player.login" {
+			t.Errorf("unexpected topic %v", topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	closeSig <- true
+	<-done
+	if bus.Publish("player.logout", 1) != 0 {
+		t.Error("event accepted after module stops")
+	}
+}
@@ -0,0 +1,187 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/LuisZhou/lpge/console"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the status of module.
+type Status int
+
+const (
+	StatusStarting   Status = iota // module is registered, and OnInit is being called.
+	StatusRunning                  // module is running.
+	StatusRestarting               // Run of module panicked, and the module is waiting for restart.
+	StatusFailed                   // module crashed and is not restarted any more.
+	StatusStopping                 // module is closed, and draining its work.
+	StatusStopped                  // module is destroyed.
+	StatusStuck                    // module is closed, but not stopped in time.
+)
+
+var statusNames = []string{"starting", "running", "restarting", "failed", "stopping", "stopped", "stuck"}
+
+func (st Status) String() string {
+	if st >= 0 && int(st) < len(statusNames) {
+		return statusNames[st]
+	}
+	return fmt.Sprintf("status(%d)", int(st))
+}
+
+// Info is a snapshot of a module.
+type Info struct {
+	Name     string         // name of module.
+	Status   Status         // status of module.
+	Started  time.Time      // time of the last start.
+	Uptime   time.Duration  // time since the last start, 0 if not running.
+	Restarts int            // times of restart, by all policies.
+	Stats    *SkeletonStats // stats of module built on Skeleton, nil if it has no stats.
+}
+
+// statser is implemented by Module having stats, such as Skeleton.
+type statser interface {
+	Stats() SkeletonStats
+}
+
+// setStatus set status of m, and the start time if it starts running.
+func (m *module) setStatus(st Status) {
+	m.mutexStatus.Lock()
+	m.status = st
+	if st == StatusRunning {
+		m.started = time.Now()
+	}
+	m.mutexStatus.Unlock()
+}
+
+// countRestart count a restart of m.
+func (m *module) countRestart() {
+	m.mutexStatus.Lock()
+	m.restarted++
+	m.mutexStatus.Unlock()
+}
+
+// failed return if m crashed and is not restarted any more.
+func (m *module) failed() bool {
+	m.mutexStatus.Lock()
+	defer m.mutexStatus.Unlock()
+	return m.status == StatusFailed
+}
+
+// info return the snapshot of m.
+func (m *module) info() Info {
+	m.mutexStatus.Lock()
+	i := Info{Name: m.name, Status: m.status, Started: m.started, Restarts: m.restarted}
+	m.mutexStatus.Unlock()
+
+	if i.Status == StatusRunning {
+		i.Uptime = time.Since(i.Started)
+	}
+	// stats are read only when the module is not being set up by OnInit.
+	if s, ok := m.Module.(statser); ok && (i.Status == StatusRunning || i.Status == StatusStopping) {
+		st := s.Stats()
+		i.Stats = &st
+	}
+	return i
+}
+
+// List return snapshots of registered modules in the order of registration. It is goroutine safe.
+func List() []Info {
+	mutex.Lock()
+	mods := make([]*module, 0, len(order))
+	for _, name := range order {
+		mods = append(mods, names[name])
+	}
+	mutex.Unlock()
+
+	infos := make([]Info, 0, len(mods))
+	for _, m := range mods {
+		infos = append(infos, m.info())
+	}
+	return infos
+}
+
+// Inspect return the snapshot of registered module of name. It is goroutine safe.
+func Inspect(name string) (Info, error) {
+	mutex.Lock()
+	m, ok := names[name]
+	mutex.Unlock()
+
+	if !ok {
+		return Info{}, fmt.Errorf("module %v is not registered", name)
+	}
+	return m.info(), nil
+}
+
+// registerCommands make RegisterCommands register commands once.
+var registerCommands sync.Once
+
+// RegisterCommands register console commands "modules" and "module" inspecting modules, it must be called before
+// console.Init.
+func RegisterCommands() {
+	registerCommands.Do(func() {
+		console.RegisterFunc("modules", "list modules with status, uptime and queues", commandModules)
+		console.RegisterFunc("module", "show details of one module", commandModule)
+	})
+}
+
+// commandModules is the console command listing all modules.
+func commandModules(args []string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%-16s %-10s %12s %8s %8s %8s %8s %8s", "NAME", "STATUS", "UPTIME", "RESTARTS", "CALLS",
+		"ASYNC", "GO", "TIMERS")
+	for _, i := range List() {
+		calls, async, gos, timers := "-", "-", "-", "-"
+		if st := i.Stats; st != nil {
+			calls = fmt.Sprint(st.Server.Depth)
+			async = fmt.Sprint(st.Client.Pending)
+			gos = fmt.Sprint(st.Pending)
+			timers = fmt.Sprint(st.Timers)
+		}
+		fmt.Fprintf(&b, "\r\n%-16s %-10s %12s %8d %8s %8s %8s %8s", i.Name, i.Status, i.Uptime.Truncate(time.Second),
+			i.Restarts, calls, async, gos, timers)
+	}
+	return b.String()
+}
+
+// commandModule is the console command showing details of one module.
+func commandModule(args []string) string {
+	if len(args) != 1 {
+		return "Usage: module <name>"
+	}
+	i, err := Inspect(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "name: %v\r\nstatus: %v\r\nuptime: %v\r\nrestarts: %v", i.Name, i.Status,
+		i.Uptime.Truncate(time.Second), i.Restarts)
+	st := i.Stats
+	if st == nil {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\r\ncalls: depth %v, max %v", st.Server.Depth, st.Server.MaxDepth)
+	fmt.Fprintf(&b, "\r\ncommands: depth %v, max %v", st.Command.Depth, st.Command.MaxDepth)
+	fmt.Fprintf(&b, "\r\nreturns: depth %v, max %v", st.Client.Depth, st.Client.MaxDepth)
+	fmt.Fprintf(&b, "\r\ngo callbacks: depth %v, max %v, cap %v", st.Go.Depth, st.Go.MaxDepth, st.Go.Cap)
+	fmt.Fprintf(&b, "\r\ntimer callbacks: depth %v, max %v, cap %v", st.Timer.Depth, st.Timer.MaxDepth, st.Timer.Cap)
+	fmt.Fprintf(&b, "\r\npending async calls: %v\r\npending go: %v\r\nactive timers: %v", st.Client.Pending,
+		st.Pending, st.Timers)
+
+	handlers := make([]string, 0, len(st.Server.Handlers))
+	for id, h := range st.Server.Handlers {
+		handlers = append(handlers, fmt.Sprintf("\r\n  %v: calls %v, errors %v, mean %v, max %v", id, h.Calls, h.Errors,
+			h.Latency.Mean(), h.Latency.Max))
+	}
+	sort.Strings(handlers)
+	if len(handlers) > 0 {
+		b.WriteString("\r\nhandlers:")
+		b.WriteString(strings.Join(handlers, ""))
+	}
+	return b.String()
+}
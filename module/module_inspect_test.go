@@ -0,0 +1,67 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/module"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	m := newDraining(1000)
+	m.RegisterChanRPC("later", func(args []interface{}) (interface{}, error) {
+		m.AfterFunc(time.Hour, func() {})
+		m.Go(func() { time.Sleep(100 * time.Millisecond) }, nil)
+		return nil, nil
+	})
+	module.Register(m, "inspect")
+	defer module.DestroyOne("inspect")
+
+	chanrpc.NewClient(10, time.Second).SynCall(m.GetChanrpcServer(), "later")
+
+	i, err := module.Inspect("inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Status != module.StatusRunning || i.Restarts != 0 || i.Started.IsZero() {
+		t.Errorf("unexpected info %+v", i)
+	}
+	if i.Stats == nil || i.Stats.Timers != 1 || i.Stats.Pending != 1 {
+		t.Fatalf("unexpected stats %+v", i.Stats)
+	}
+	if i.Stats.Server.Handlers["later"].Calls != 1 {
+		t.Errorf("unexpected stats of server %+v", i.Stats.Server)
+	}
+
+	found := false
+	for _, i := range module.List() {
+		found = found || i.Name == "inspect"
+	}
+	if !found {
+		t.Error("module not listed")
+	}
+
+	if _, err := module.Inspect("inspect-none"); err == nil {
+		t.Error("expect error of unknown module")
+	}
+}
+
+func TestInspectRestart(t *testing.T) {
+	ch, stop := lifecycle("inspect-crashy")
+	defer stop()
+
+	m := newCrashy()
+	module.Register(m, "inspect-crashy", module.RestartPolicy{Strategy: module.OneForOne})
+	m.crash <- true
+	expect(t, ch, "inspect-crashy module.started", "inspect-crashy module.crashed", "inspect-crashy module.restarted")
+
+	i, _ := module.Inspect("inspect-crashy")
+	if i.Status != module.StatusRunning || i.Restarts != 1 {
+		t.Errorf("unexpected info %+v", i)
+	}
+
+	module.DestroyOne("inspect-crashy")
+	if _, err := module.Inspect("inspect-crashy"); err == nil {
+		t.Error("expect destroyed module not inspected")
+	}
+}
@@ -0,0 +1,381 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/event"
+	"github.com/LuisZhou/lpge/log"
+	"github.com/LuisZhou/lpge/timer"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// JournalKind is the kind of item in journal.
+type JournalKind uint8
+
+const (
+	JournalCall    JournalKind = iota // call executed by rpc server.
+	JournalCommand                    // call executed by command server.
+	JournalAsynRet                    // return of async call passed to callback.
+	JournalCallRet                    // result of call made by client, the return of sync call or the error of sending.
+	JournalTimer                      // fire of timer or cron.
+	JournalGo                         // callback of Go.
+	JournalEvent                      // event delivered to subscription made by Subscribe.
+)
+
+// JournalEntry is a item processed by the loop of Skeleton.
+type JournalEntry struct {
+	Kind  JournalKind   // kind of entry.
+	Seq   uint64        // sequence of call in client, sequence of timer, cron and Go in Skeleton, or index of subscription.
+	Id    interface{}   // id of call, or topic of event.
+	Args  []interface{} // args of call, or event.
+	Ret   interface{}   // value of return.
+	Err   string        // error of return, empty if no error.
+	Item  bool          // return is a stream item.
+	Lossy bool          // args and ret are dropped, as they can not be encoded.
+	Time  time.Time     // time of recording.
+}
+
+// recorder write entries to journal.
+type recorder struct {
+	w     io.Writer  // journal.
+	err   error      // error of writing, no more entry is written after it.
+	mutex sync.Mutex // calls of client may be made in other goroutine, such as f of Go.
+}
+
+// Record journal every item processed by Run to w, so the state of s can be reproduced by Replay later.
+//
+// Record must be called after Init, and before s makes any call, timer or Go, then the sequences in journal match the
+// ones of a fresh Skeleton. Values in journal are encoded by encoding/gob, concrete types other than basic types must
+// be registered by gob.Register, or args and ret of the entry are dropped. Events are journaled by topic and event,
+// for subscriptions made by Subscribe. Calls made by LinearContext are not journaled.
+func (s *Skeleton) Record(w io.Writer) {
+	s.recorder = &recorder{w: w}
+
+	s.server.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		if ci.Id() == event.Id {
+			s.recorder.write(s.eventEntry(ci.Args()))
+		} else {
+			s.recorder.write(&JournalEntry{Kind: JournalCall, Id: ci.Id(), Args: ci.Args()})
+		}
+		return next(ci)
+	})
+	s.commandServer.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		s.recorder.write(&JournalEntry{Kind: JournalCommand, Id: ci.Id(), Args: ci.Args()})
+		return next(ci)
+	})
+	s.client.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		ret, err := next(ci)
+		e := &JournalEntry{Kind: JournalCallRet, Seq: ci.Seq(), Id: ci.Id(), Err: errorString(err)}
+		if ci.IsSync() {
+			e.Ret = ret
+		}
+		s.recorder.write(e)
+		return ret, err
+	})
+}
+
+// eventEntry return the entry of event delivery of args, the subscription is journaled by its index in subs of s. It is
+// lossy if the subscription is not made by Subscribe.
+func (s *Skeleton) eventEntry(args []interface{}) *JournalEntry {
+	e := &JournalEntry{Kind: JournalEvent, Id: args[1], Args: args[2:]}
+	for i, sub := range s.subs {
+		if sub == args[0] {
+			e.Seq = uint64(i)
+			return e
+		}
+	}
+	e.Args, e.Lossy = nil, true
+	return e
+}
+
+// write append e to journal.
+func (r *recorder) write(e *JournalEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	e.Time = time.Now()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		buf.Reset()
+		e.Args, e.Ret, e.Lossy = nil, nil, true
+		if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+			log.Error("journal entry of %v: %v", e.Id, err)
+			return
+		}
+	}
+
+	// each entry is a frame of its own, so a bad value never breaks the following ones.
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(buf.Len()))
+	if _, r.err = r.w.Write(size[:n]); r.err == nil {
+		_, r.err = r.w.Write(buf.Bytes())
+	}
+	if r.err != nil {
+		log.Error("journal stopped: %v", r.err)
+	}
+}
+
+// asynRet journal ri before it is passed to callback.
+func (r *recorder) asynRet(ri *chanrpc.RetInfo) {
+	r.write(&JournalEntry{Kind: JournalAsynRet, Seq: ri.Seq(), Ret: ri.Ret(), Err: errorString(ri.Err()), Item: ri.IsItem()})
+}
+
+// task wrap cb of timer, cron or Go, so each call of it is journaled.
+func (r *recorder) task(kind JournalKind, seq uint64, cb func()) func() {
+	return func() {
+		r.write(&JournalEntry{Kind: kind, Seq: seq})
+		if cb != nil {
+			cb()
+		}
+	}
+}
+
+// replayer feed entries of journal to Skeleton.
+type replayer struct {
+	r       *bufio.Reader                // journal.
+	backlog []*JournalEntry              // entries read ahead while looking for the result of a call.
+	rets    map[uint64]*JournalEntry     // results of calls read ahead.
+	calls   map[uint64]*chanrpc.CallInfo // async calls waiting for return.
+	timers  map[uint64]*replayTimer      // timers and crons not fired.
+	gos     map[uint64]*replayGo         // Go not called back.
+	err     error                        // error of replay, such as diverging from journal.
+}
+
+// replayTimer is a timer or cron to be fired by journal.
+type replayTimer struct {
+	cb   func() // callback.
+	cron bool   // fired many times.
+}
+
+// replayGo is a Go to be called back by journal.
+type replayGo struct {
+	f  func() // function.
+	cb func() // callback.
+}
+
+// Replay feed the journal written by Record to s, which is a fresh Skeleton set up like the recorded one, to reproduce
+// its state. s must not Run.
+//
+// Entries are processed in the goroutine of caller and in the recorded order: calls are executed by the handlers,
+// returns are passed to the callbacks of async calls, timers fire and Go calls back without waiting, f of Go is
+// executed right before its callback. Calls made by s are not sent, their results come from the journal. Replay return
+// nil at the end of journal, or a error if the journal can not be read or s does not make the same calls as recorded.
+func (s *Skeleton) Replay(r io.Reader) error {
+	p := &replayer{
+		r:      bufio.NewReader(r),
+		rets:   make(map[uint64]*JournalEntry),
+		calls:  make(map[uint64]*chanrpc.CallInfo),
+		timers: make(map[uint64]*replayTimer),
+		gos:    make(map[uint64]*replayGo),
+	}
+	s.replayer = p
+
+	s.client.Use(func(ci *chanrpc.CallInfo, next chanrpc.Invoker) (interface{}, error) {
+		e, err := p.result(ci)
+		if err != nil {
+			p.fail(err)
+			return nil, err
+		}
+		if e.Err == "" && !ci.IsSync() {
+			p.calls[ci.Seq()] = ci
+		}
+		return e.Ret, errorOf(e.Err)
+	})
+
+	for p.err == nil {
+		e, err := p.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Lossy {
+			return fmt.Errorf("journal entry %v of %v is lossy", e.Seq, e.Id)
+		}
+
+		switch e.Kind {
+		case JournalCall:
+			s.server.Invoke(e.Id, e.Args...)
+		case JournalCommand:
+			s.commandServer.Invoke(e.Id, e.Args...)
+		case JournalEvent:
+			if e.Seq >= uint64(len(s.subs)) {
+				return fmt.Errorf("replay diverged: subscription %v is not made", e.Seq)
+			}
+			var ev interface{}
+			if len(e.Args) > 0 {
+				ev = e.Args[0]
+			}
+			s.server.Invoke(event.Id, s.subs[e.Seq], e.Id, ev)
+		case JournalAsynRet:
+			// a call given up by done context may still have a return.
+			ci := p.calls[e.Seq]
+			if ci == nil {
+				continue
+			}
+			if !e.Item {
+				delete(p.calls, e.Seq)
+			}
+			protect(func() { ci.Deliver(e.Ret, errorOf(e.Err), e.Item) })
+		case JournalCallRet:
+			p.rets[e.Seq] = e
+		case JournalTimer:
+			t := p.timers[e.Seq]
+			if t == nil {
+				return fmt.Errorf("replay diverged: timer %v is not set", e.Seq)
+			}
+			if !t.cron {
+				delete(p.timers, e.Seq)
+			}
+			protect(t.cb)
+		case JournalGo:
+			g := p.gos[e.Seq]
+			if g == nil {
+				return fmt.Errorf("replay diverged: go %v is not called", e.Seq)
+			}
+			delete(p.gos, e.Seq)
+			protect(g.f)
+			if g.cb != nil {
+				protect(g.cb)
+			}
+		default:
+			return fmt.Errorf("unknown journal entry kind %v", e.Kind)
+		}
+	}
+
+	return p.err
+}
+
+// read decode a entry from journal.
+func (p *replayer) read() (*JournalEntry, error) {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, err
+	}
+
+	e := new(JournalEntry)
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// next return the next entry to process, entries read ahead go first.
+func (p *replayer) next() (*JournalEntry, error) {
+	if len(p.backlog) > 0 {
+		e := p.backlog[0]
+		p.backlog = p.backlog[1:]
+		return e, nil
+	}
+	return p.read()
+}
+
+// result return the journaled result of call ci, entries before it are kept for the loop.
+func (p *replayer) result(ci *chanrpc.CallInfo) (*JournalEntry, error) {
+	e, ok := p.rets[ci.Seq()]
+	for !ok {
+		var err error
+		if e, err = p.read(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("replay diverged: call %v of %v is not in journal", ci.Seq(), ci.Id())
+			}
+			return nil, err
+		}
+		if e.Kind != JournalCallRet {
+			p.backlog = append(p.backlog, e)
+			continue
+		}
+		if e.Seq != ci.Seq() {
+			p.rets[e.Seq] = e
+			continue
+		}
+		ok = true
+	}
+
+	delete(p.rets, ci.Seq())
+	if e.Id != ci.Id() {
+		return nil, fmt.Errorf("replay diverged: call %v is %v, but %v in journal", ci.Seq(), ci.Id(), e.Id)
+	}
+	return e, nil
+}
+
+// fail stop replay with err, the first error is kept.
+func (p *replayer) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// afterFunc keep cb of timer of sequence seq, to fire it by journal.
+func (p *replayer) afterFunc(seq uint64, cb func()) *timer.Timer {
+	p.timers[seq] = &replayTimer{cb: cb}
+	return new(timer.Timer)
+}
+
+// cronFunc keep cb of cron of sequence seq, to fire it by journal.
+func (p *replayer) cronFunc(seq uint64, cb func()) *timer.Cron {
+	p.timers[seq] = &replayTimer{cb: cb, cron: true}
+	return new(timer.Cron)
+}
+
+// goFunc keep f and cb of Go of sequence seq, to call them by journal.
+func (p *replayer) goFunc(seq uint64, f func(), cb func()) {
+	p.gos[seq] = &replayGo{f: f, cb: cb}
+}
+
+// protect call f, and log the panic of it like the loop of Skeleton does.
+func protect(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("%v: %s", r, buf[:l])
+			} else {
+				log.Error("%v", r)
+			}
+		}
+	}()
+
+	f()
+}
+
+// errorString return the message of err, empty if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// errorOf return the error of message s, known errors are restored, so they can still be compared.
+func errorOf(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, chanrpc.ErrBusy} {
+		if s == err.Error() {
+			return err
+		}
+	}
+	return errors.New(s)
+}
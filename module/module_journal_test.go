@@ -0,0 +1,131 @@
+package module_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/event"
+	"github.com/LuisZhou/lpge/module"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// journaled is a module whose state depends on the order of calls, returns, timers and Go.
+type journaled struct {
+	*module.Skeleton
+	log []string
+}
+
+func newJournaled(remote *chanrpc.Server) *journaled {
+	m := &journaled{Skeleton: &module.Skeleton{AsynCallLen: 10, ChanRPCLen: 10, GoLen: 10, TimerDispatcherLen: 10,
+		Bus: event.NewBus()}}
+	m.Init()
+
+	m.Subscribe("score.*", func(topic string, e interface{}) {
+		m.log = append(m.log, fmt.Sprintf("%v %v", topic, e))
+	})
+	m.RegisterChanRPC("push", func(args []interface{}) (interface{}, error) {
+		m.log = append(m.log, "push "+args[0].(string))
+		return nil, nil
+	})
+	m.RegisterChanRPC("fetch", func(args []interface{}) (interface{}, error) {
+		m.AsynCall(remote, "echo", args[0], func(ret interface{}, err error) {
+			m.log = append(m.log, "fetched "+ret.(string))
+		})
+		ret, _ := m.SynCall(remote, "echo", "sync")
+		m.log = append(m.log, "synced "+ret.(string))
+		return nil, nil
+	})
+	m.RegisterChanRPC("later", func(args []interface{}) (interface{}, error) {
+		m.AfterFunc(time.Millisecond, func() {
+			m.log = append(m.log, "timer")
+		})
+		var v string
+		m.Go(func() {
+			v = "go"
+		}, func() {
+			m.log = append(m.log, v)
+		})
+		return nil, nil
+	})
+	return m
+}
+
+func TestRecordReplay(t *testing.T) {
+	remote := chanrpc.NewServer(10, time.Second)
+	remote.Register("echo", func(args []interface{}) (interface{}, error) {
+		return args[0], nil
+	})
+	closeRemote := make(chan bool)
+	go func() {
+		for {
+			select {
+			case ci := <-remote.ChanCall:
+				remote.Exec(ci)
+			case <-closeRemote:
+				return
+			}
+		}
+	}()
+	defer close(closeRemote)
+
+	var journal bytes.Buffer
+	m := newJournaled(remote)
+	m.Record(&journal)
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		m.Run(closeSig)
+		done <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+	c.SynCall(m.GetChanrpcServer(), "push", "a")
+	c.SynCall(m.GetChanrpcServer(), "fetch", "b")
+	c.SynCall(m.GetChanrpcServer(), "later")
+	c.SynCall(m.GetChanrpcServer(), "push", "c")
+	m.Publish("score.add", 10)
+	time.Sleep(100 * time.Millisecond)
+	closeSig <- true
+	<-done
+
+	if len(m.log) != 7 {
+		t.Fatal("unexpected record", m.log)
+	}
+
+	r := newJournaled(nil)
+	if err := r.Replay(&journal); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.log, m.log) {
+		t.Fatal("replay diverged", r.log, m.log)
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	var journal bytes.Buffer
+	s := &module.Skeleton{ChanRPCLen: 10}
+	s.Init()
+	s.RegisterChanRPC("fetch", func(args []interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	s.Record(&journal)
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		s.Run(closeSig)
+		done <- true
+	}()
+	chanrpc.NewClient(10, time.Second).SynCall(s.GetChanrpcServer(), "fetch", "a")
+	closeSig <- true
+	<-done
+
+	// the recorded module makes no call, but the replayed one does.
+	r := newJournaled(chanrpc.NewServer(10, time.Second))
+	if err := r.Replay(&journal); err == nil {
+		t.Fatal("replay should diverge")
+	}
+}
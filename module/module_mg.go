@@ -2,14 +2,17 @@
 package module
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LuisZhou/lpge/chanrpc"
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/log"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Module interface defines what API a module should implement.
@@ -23,52 +26,176 @@ type Module interface {
 	GetChanrpcServer() *chanrpc.Server                                                        // get its rpc server.
 }
 
+// Dependent is implemented by Module depending on other modules, which must be registered before it and destroyed after
+// it.
+type Dependent interface {
+	Depends() []string // names of modules depended on.
+}
+
 // module is a the unit, the module manager can manage.
 type module struct {
-	Module                  // Module interface.
-	closeSig chan bool      // closeSig is used to destory Module.
-	wg       sync.WaitGroup // wg is used to wait Module stop running completely for the manager.
+	Module                     // Module interface.
+	name        string         // name of module.
+	closeSig    chan bool      // closeSig is used to destory Module.
+	wg          sync.WaitGroup // wg is used to wait Module stop running completely for the manager.
+	policy      RestartPolicy  // restart policy when Run panics.
+	restarts    []time.Time    // time of restarts in window of policy.
+	lock        sync.Mutex     // lock serialize stopping and starting of Module.
+	status      Status         // status of module.
+	started     time.Time      // time of the last start.
+	restarted   int            // times of restart.
+	mutexStatus sync.Mutex     // mutex protect status, started, restarted and restarts.
 }
 
 var (
 	names map[string]*module = make(map[string]*module) // map between name and module.
+	order []string                                      // names of modules in the order of registration.
 	addr  uint               = 0                        // addr counter for assign name to module when there is no name explictly.
 	mutex sync.Mutex                                    // mutex for protect register
 )
 
-// Register a Module to this manager.
-func Register(mi Module, name string) (err error) {
+// Register a Module to this manager. policy is the restart policy when Run of module panics, if not given, it is the one
+// of Supervised, or Temporary.
+func Register(mi Module, name string, policy ...RestartPolicy) (err error) {
 	mutex.Lock()
-	defer func() {
-		mutex.Unlock()
-	}()
+	m, err := add(mi, name, policy...)
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// OnInit may search other modules.
+	m.init()
+	m.start()
+	publish(TopicStarted, m, nil)
+
+	return nil
+}
+
+// add add module mi of name to the manager, the caller should get the lock.
+func add(mi Module, name string, policy ...RestartPolicy) (*module, error) {
 
 	namesz := len(name)
 	if namesz > 0 {
 		if _, ok := names[name]; ok {
-			return fmt.Errorf("dulplicate name of module")
+			return nil, fmt.Errorf("dulplicate name of module")
 		}
 	} else {
 		addr++
 		name = strconv.Itoa(int(addr))
 	}
 
+	if d, ok := mi.(Dependent); ok {
+		for _, dep := range d.Depends() {
+			if _, ok := names[dep]; !ok {
+				return nil, fmt.Errorf("module %v depends on %v, which is not registered", name, dep)
+			}
+		}
+	}
+
 	m := new(module)
 	m.Module = mi
+	m.name = name
 	m.closeSig = make(chan bool, 1)
+	if len(policy) > 0 {
+		m.policy = policy[0]
+	} else if sv, ok := mi.(Supervised); ok {
+		m.policy = sv.RestartPolicy()
+	}
 	names[name] = m
+	order = append(order, name)
+	return m, nil
+}
 
-	m.Module.OnInit()
-	m.wg.Add(1)
-	go run(m)
+// RegisterAll register modules of mods, each after the modules it depends on. It return error if a module depends on
+// a module neither in mods nor registered, or modules depend on each other in a cycle. Errors of dependency are found
+// before any module is registered.
+func RegisterAll(mods map[string]Module) error {
+	sorted, err := sortModules(mods)
+	if err != nil {
+		return err
+	}
 
+	for _, name := range sorted {
+		if err := Register(mods[name], name); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// run start running of module.
-func run(m *module) {
-	m.Module.Run(m.closeSig)
-	m.wg.Done()
+// sortModules return names of mods in topological order of dependency, names of the same level are sorted, so the order
+// is stable.
+func sortModules(mods map[string]Module) ([]string, error) {
+	keys := make([]string, 0, len(mods))
+	for name := range mods {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var sorted []string
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return fmt.Errorf("cyclic dependency of modules: %v", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		if d, ok := mods[name].(Dependent); ok {
+			for _, dep := range d.Depends() {
+				if _, ok := mods[dep]; ok {
+					if err := visit(dep); err != nil {
+						return err
+					}
+				} else if _, ok := names[dep]; !ok {
+					return fmt.Errorf("module %v depends on %v, which is not registered", name, dep)
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		sorted = append(sorted, name)
+		return nil
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, name := range keys {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// init call OnInit of module, and name its rpc server.
+func (m *module) init() {
+	m.Module.OnInit()
+	if server := m.Module.GetChanrpcServer(); server != nil {
+		server.SetName(m.name)
+	}
+}
+
+// start start running of module.
+func (m *module) start() {
+	m.setStatus(StatusRunning)
+	m.wg.Add(1)
+	go run(m)
 }
 
 // destory do destory module internal.
@@ -87,30 +214,105 @@ func destroy(m *module) {
 	m.Module.OnDestroy()
 }
 
-// Destory all module.
-func Destroy() {
-	for k, _ := range names {
-		DestroyOne(k)
+// Destory all module, in the reverse order of registration, so a module is destroyed before the modules it depends on.
+// It return the error reporting modules not stopped cleanly.
+func Destroy() error {
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := DestroyOne(order[i]); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
 }
 
-// Destory one module.
-func DestroyOne(name string) {
-	m, _ := names[name]
-	m.closeSig <- true
-	m.wg.Wait()
-	destroy(m)
+// Destory one module, it waits for the module to drain its work within the drain timeout, and return the error
+// reporting the work left. A module not stopped in time is kept with StatusStuck, and can be destroyed again. A module
+// depended on by registered modules is not destroyed, they must be destroyed first.
+func DestroyOne(name string) error {
+	mutex.Lock()
+	m, ok := names[name]
+	dependents := dependentsOf(name)
+	mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("module %v is not registered", name)
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("module %v is depended on by %v", name, strings.Join(dependents, ", "))
+	}
+
+	err := m.stop()
+	if errors.Is(err, ErrStuck) {
+		return err
+	}
+	publish(TopicStopped, m, nil)
+
+	mutex.Lock()
+	defer mutex.Unlock()
 	delete(names, name)
+	for i, n := range order {
+		if n == name {
+			order = append(order[:i], order[i+1:]...)
+			break
+		}
+	}
+	return err
 }
 
-// Search module according its name.
+// dependentsOf return names of registered modules depending on module name, the caller should get the lock.
+func dependentsOf(name string) []string {
+	var dependents []string
+	for _, n := range order {
+		if d, ok := names[n].Module.(Dependent); ok {
+			for _, dep := range d.Depends() {
+				if dep == name {
+					dependents = append(dependents, n)
+					break
+				}
+			}
+		}
+	}
+	return dependents
+}
+
+// Remote is the transport of calls to modules of other nodes, which is set by package cluster.
+type Remote interface {
+	Call(node string, name string, id interface{}, args ...interface{}) (interface{}, error) // call module of node.
+}
+
+var (
+	remote      Remote     // transport of calls to other nodes, nil if not in cluster.
+	mutexRemote sync.Mutex // mutex protect remote.
+)
+
+// SetRemote set the transport of calls to modules of other nodes, nil to leave cluster.
+func SetRemote(r Remote) {
+	mutexRemote.Lock()
+	remote = r
+	mutexRemote.Unlock()
+}
+
+// getRemote return the transport of calls to other nodes.
+func getRemote() Remote {
+	mutexRemote.Lock()
+	defer mutexRemote.Unlock()
+	return remote
+}
+
+// Search module according its name. A name of "node:name" is of a remote module, which is not returned.
 func Search(name string) (m *module, is_remote bool, err error) {
 	arr := strings.Split(name, ":")
 	len_of_arr := len(arr)
 	if len_of_arr == 2 {
-		return nil, true, fmt.Errorf("Not support remote name now: %s", name)
+		if getRemote() == nil {
+			return nil, true, fmt.Errorf("Not in cluster for remote name: %s", name)
+		}
+		return nil, true, nil
 	} else if len_of_arr == 1 {
-		if m, ok := names[name]; ok {
+		mutex.Lock()
+		m, ok := names[name]
+		mutex.Unlock()
+		if ok {
 			return m, false, nil
 		} else {
 			return nil, false, fmt.Errorf("Not found for name: %s", name)
@@ -120,32 +322,54 @@ func Search(name string) (m *module, is_remote bool, err error) {
 	}
 }
 
-// AsynCall do a async call to module.
-func (m *module) AsynCall(name string, cmd uint16, data interface{}) error {
+// goer is implemented by Module running function with callback in its goroutine, such as Skeleton.
+type goer interface {
+	Go(f func(), cb func())
+}
+
+// AsynCall do a async call to module, local or remote. cb is called with the return in the goroutine of module, a
+// remote call needs the module be able to Go.
+func (m *module) AsynCall(name string, cmd uint16, data interface{}, cb ...func(interface{}, error)) error {
 	m1, is_remote, err := Search(name)
 	if err != nil {
 		return err
 	}
 
+	f := func(interface{}, error) {}
+	if len(cb) > 0 && cb[0] != nil {
+		f = cb[0]
+	}
+
 	if !is_remote {
-		m.Module.AsynCall(m1.Module.GetChanrpcServer(), cmd, data, func(interface{}, error) {})
-	} else {
+		return m.Module.AsynCall(m1.Module.GetChanrpcServer(), cmd, data, f)
 	}
 
+	g, ok := m.Module.(goer)
+	if !ok {
+		return fmt.Errorf("module %v can not call remote module %v asynchronously", m.name, name)
+	}
+	r := getRemote()
+	arr := strings.Split(name, ":")
+	var ret interface{}
+	g.Go(func() {
+		ret, err = r.Call(arr[0], arr[1], cmd, data)
+	}, func() {
+		f(ret, err)
+	})
 	return nil
 }
 
-// SynCall do a synCall call to module.
-func (m *module) SynCall(name string, cmd uint16, data interface{}) error {
+// SynCall do a synCall call to module, local or remote, and return its return.
+func (m *module) SynCall(name string, cmd uint16, data interface{}) (interface{}, error) {
 	m1, is_remote, err := Search(name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !is_remote {
-		m.Module.SynCall(m1.Module.GetChanrpcServer(), cmd, data)
-	} else {
+		return m.Module.SynCall(m1.Module.GetChanrpcServer(), cmd, data)
 	}
 
-	return nil
+	arr := strings.Split(name, ":")
+	return getRemote().Call(arr[0], arr[1], cmd, data)
 }
@@ -0,0 +1,179 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/log"
+	"time"
+)
+
+// Stopper is implemented by Module which should stop taking new work before any module is destroyed, such as a gate
+// accepting connections.
+type Stopper interface {
+	OnStop() // stop taking new work, the work taken is still done.
+}
+
+// Drainer is implemented by Module draining its work when closed, such as Skeleton.
+type Drainer interface {
+	DrainTimeout() time.Duration // max time of draining, no limit if not positive.
+	Leftover() error             // work left when draining timed out, nil if all is done.
+}
+
+// drainGrace is the time given to module to stop after its drain timeout.
+const drainGrace = time.Second
+
+// errAborted is returned to calls aborted when draining timed out.
+var errAborted = errors.New("call aborted, module is stopped")
+
+// ErrStuck is reported by DestroyOne for module not stopped in time, it is kept registered with StatusStuck.
+var ErrStuck = errors.New("not stopped")
+
+// Shutdown destroy all modules in stages. First OnStop of every Stopper is called in the reverse order of registration,
+// then modules are destroyed in the reverse order, each draining its work within its timeout. It return the error
+// reporting modules not stopped cleanly.
+func Shutdown() error {
+	mutex.Lock()
+	var stoppers []*module
+	for i := len(order) - 1; i >= 0; i-- {
+		stoppers = append(stoppers, names[order[i]])
+	}
+	mutex.Unlock()
+
+	for _, m := range stoppers {
+		if st, ok := m.Module.(Stopper); ok {
+			if err := catch(st.OnStop); err != nil {
+				log.Error("module %v failed to stop: %v", m.name, err)
+			}
+		}
+	}
+
+	return Destroy()
+}
+
+// drainTimeout return the drain timeout of m.
+func (m *module) drainTimeout() time.Duration {
+	if d, ok := m.Module.(Drainer); ok {
+		return d.DrainTimeout()
+	}
+	return time.Duration(conf.DrainTimeout) * time.Millisecond
+}
+
+// stop close m and wait for it to stop within its drain timeout, and return the error reporting work left. A module
+// not stopped in time is left running with StatusStuck, without OnDestroy called, and ErrStuck is returned.
+func (m *module) stop() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.setStatus(StatusStopping)
+	// the signal of a stop timed out may be pending still.
+	select {
+	case m.closeSig <- true:
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if d := m.drainTimeout(); d > 0 {
+		t := time.NewTimer(d + drainGrace)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-timeout:
+		m.setStatus(StatusStuck)
+		return fmt.Errorf("module %v: %w in %v", m.name, ErrStuck, m.drainTimeout())
+	}
+
+	destroy(m)
+	m.setStatus(StatusStopped)
+	if d, ok := m.Module.(Drainer); ok {
+		if err := d.Leftover(); err != nil {
+			return fmt.Errorf("module %v: %v", m.name, err)
+		}
+	}
+	return nil
+}
+
+// DrainTimeout return the max time of draining when closed.
+func (s *Skeleton) DrainTimeout() time.Duration {
+	if s.TimeoutDrain != 0 {
+		return time.Duration(s.TimeoutDrain) * time.Millisecond
+	}
+	return time.Duration(conf.DrainTimeout) * time.Millisecond
+}
+
+// Leftover return the error reporting work left when draining timed out, nil if all is done. It is goroutine safe.
+func (s *Skeleton) Leftover() error {
+	s.mutexLeftover.Lock()
+	defer s.mutexLeftover.Unlock()
+	return s.leftover
+}
+
+// idle return if s has no call, return and callback of Go to handle.
+func (s *Skeleton) idle() bool {
+	return s.server.Depth() == 0 && s.commandServer.Depth() == 0 && s.client.Pending() == 0 && s.g.Idle()
+}
+
+// drain stop s. Calls, returns of async calls and callbacks of Go are handled until all are done, or the drain timeout,
+// when calls left are aborted, and returns and callbacks of Go left are dropped. Actors are passivated at last.
+func (s *Skeleton) drain() {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+
+	var timeout <-chan time.Time
+	if d := s.DrainTimeout(); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for !s.idle() {
+		select {
+		case ri := <-s.client.ChanAsynRet:
+			s.loop(func() { s.cb(ri) })
+		case ci := <-s.server.ChanSystem:
+			s.server.Serve(ci)
+		case ci := <-s.server.ChanCall:
+			s.server.Serve(ci)
+		case ci := <-s.server.ChanBulk:
+			s.server.Serve(ci)
+		case ci := <-s.commandServer.ChanCall:
+			s.loop(func() { s.commandServer.Exec(ci) })
+		case cb := <-s.g.ChanCb:
+			s.loop(func() { s.g.Cb(cb) })
+		case <-timeout:
+			s.abort()
+			s.passivateActors()
+			s.client.Close()
+			return
+		}
+	}
+
+	s.commandServer.Close()
+	s.server.Close()
+	s.passivateActors()
+	s.g.Close()
+	s.client.Close()
+}
+
+// abort stop s at once, and keep the report of work left.
+func (s *Skeleton) abort() {
+	rets, gos := s.client.Pending(), s.g.Pending()
+	calls := s.commandServer.Abort(errAborted) + s.server.Abort(errAborted)
+
+	err := fmt.Errorf("drain timed out, %v calls aborted, %v returns and %v callbacks of Go dropped", calls, rets, gos)
+	log.Error("%v", err)
+
+	s.mutexLeftover.Lock()
+	s.leftover = err
+	s.mutexLeftover.Unlock()
+}
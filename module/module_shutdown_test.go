@@ -0,0 +1,144 @@
+package module_test
+
+import (
+	"errors"
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/module"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// draining is a module whose calls take time.
+type draining struct {
+	*module.Skeleton
+	done    int32
+	stopped int32
+}
+
+func newDraining(timeout int) *draining {
+	m := &draining{Skeleton: &module.Skeleton{ChanRPCLen: 10, AsynCallLen: 10, TimeoutDrain: timeout}}
+	m.Init()
+	m.RegisterChanRPC("work", func(args []interface{}) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&m.done, 1)
+		return nil, nil
+	})
+	return m
+}
+
+func (m *draining) OnInit() {
+}
+
+func (m *draining) OnDestroy() {
+}
+
+func (m *draining) OnStop() {
+	atomic.StoreInt32(&m.stopped, 1)
+}
+
+func TestDrain(t *testing.T) {
+	m := newDraining(1000)
+	module.Register(m, "drain-all")
+
+	c := chanrpc.NewClient(10, time.Second)
+	for i := 0; i < 5; i++ {
+		if err := c.AsynCall(m.GetChanrpcServer(), "work", func(interface{}, error) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := module.DestroyOne("drain-all"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&m.done); n != 5 {
+		t.Errorf("expect 5 calls drained, got %v", n)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	m := newDraining(25)
+	hold := make(chan bool)
+	m.RegisterChanRPC("hold", func(args []interface{}) (interface{}, error) {
+		<-hold
+		return nil, nil
+	})
+	module.Register(m, "drain-timeout")
+
+	// work is not started before the module is destroyed.
+	c := chanrpc.NewClient(11, time.Second)
+	if err := c.AsynCall(m.GetChanrpcServer(), "hold", func(interface{}, error) {}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.AsynCall(m.GetChanrpcServer(), "work", func(interface{}, error) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	time.AfterFunc(10*time.Millisecond, func() {
+		close(hold)
+	})
+	if err := module.DestroyOne("drain-timeout"); err == nil {
+		t.Fatal("expect work left")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expect drain aborted, stopped in %v", d)
+	}
+
+	// calls aborted are returned with error.
+	aborted := 0
+	for c.Pending() > 0 {
+		ri := c.Cb(<-c.ChanAsynRet)
+		if ri.Err() != nil {
+			aborted++
+		}
+	}
+	if n := atomic.LoadInt32(&m.done); aborted == 0 || int(n)+aborted != 10 {
+		t.Errorf("expect calls aborted, %v done and %v aborted", n, aborted)
+	}
+}
+
+func TestDrainStuck(t *testing.T) {
+	m := newDraining(25)
+	release := make(chan bool)
+	m.RegisterChanRPC("block", func(args []interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	module.Register(m, "drain-stuck")
+
+	c := chanrpc.NewClient(10, time.Second)
+	if err := c.AsynCall(m.GetChanrpcServer(), "block", func(interface{}, error) {}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// the module not stopped is kept.
+	if err := module.DestroyOne("drain-stuck"); !errors.Is(err, module.ErrStuck) {
+		t.Fatalf("expect stuck, got %v", err)
+	}
+	if i, err := module.Inspect("drain-stuck"); err != nil || i.Status != module.StatusStuck {
+		t.Fatalf("expect module stuck, got %v %v", i.Status, err)
+	}
+
+	close(release)
+	module.DestroyOne("drain-stuck")
+	if _, err := module.Inspect("drain-stuck"); err == nil {
+		t.Error("expect module removed")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	a, b := newDraining(1000), newDraining(1000)
+	module.Register(a, "shutdown-a")
+	module.Register(b, "shutdown-b")
+
+	if err := module.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&a.stopped) != 1 || atomic.LoadInt32(&b.stopped) != 1 {
+		t.Error("expect modules stopped before destroyed")
+	}
+}
@@ -1,25 +1,39 @@
 package module
 
 import (
+	"context"
 	"github.com/LuisZhou/lpge/chanrpc"
 	"github.com/LuisZhou/lpge/console"
+	"github.com/LuisZhou/lpge/event"
 	"github.com/LuisZhou/lpge/go"
 	"github.com/LuisZhou/lpge/timer"
+	"sync"
 	"time"
 )
 
 // Skeleton implements main function of module.
 type Skeleton struct {
-	GoLen              int               // len of call-channel of Go.
-	TimerDispatcherLen int               // len of call-channel of Timer.
-	AsynCallLen        int               // len of channel of return of async call of rpc.
-	ChanRPCLen         int               // len of channel of called of rpc.
-	TimeoutAsynRet     int               // timeout of wait for return of rpc.
-	g                  *g.Go             // Go module.
-	dispatcher         *timer.Dispatcher // Timer module.
-	client             *chanrpc.Client   // Client of rpc.
-	server             *chanrpc.Server   // Server of rpc.
-	commandServer      *chanrpc.Server   // Command module.
+	GoLen              int                   // len of call-channel of Go.
+	TimerDispatcherLen int                   // len of call-channel of Timer.
+	AsynCallLen        int                   // len of channel of return of async call of rpc.
+	ChanRPCLen         int                   // len of channel of called of rpc.
+	TimeoutAsynRet     int                   // timeout of wait for return of rpc.
+	TimeoutDrain       int                   // max millisecond of draining when closed, conf.DrainTimeout if 0, no limit if negative.
+	Bus                *event.Bus            // event bus of Subscribe and Publish, event.Default if nil.
+	g                  *g.Go                 // Go module.
+	dispatcher         *timer.Dispatcher     // Timer module.
+	client             *chanrpc.Client       // Client of rpc.
+	server             *chanrpc.Server       // Server of rpc.
+	commandServer      *chanrpc.Server       // Command module.
+	task               uint64                // sequence of the last timer, cron or Go, used by journal.
+	recorder           *recorder             // recorder of journal, nil if not recording.
+	replayer           *replayer             // replayer of journal, nil if not replaying.
+	maxCb              int64                 // high-water mark of ChanCb of Go.
+	maxTimer           int64                 // high-water mark of ChanTimer.
+	subs               []*event.Subscription // subscriptions, unsubscribed when Run stops.
+	actors             []*Actors             // actors, passivated when Run stops.
+	leftover           error                 // work left when drain timed out.
+	mutexLeftover      sync.Mutex            // mutex protect leftover.
 }
 
 // Init do init of internal module.
@@ -39,11 +53,16 @@ func (s *Skeleton) Init() {
 	if s.TimeoutAsynRet < 0 {
 		s.TimeoutAsynRet = 0
 	}
+	if s.Bus == nil {
+		s.Bus = event.Default
+	}
 	s.g = g.New(s.GoLen)
 	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
 	s.client = chanrpc.NewClient(s.AsynCallLen, 10)
 	s.server = chanrpc.NewServer(s.ChanRPCLen, time.Duration(s.TimeoutAsynRet))
+	s.client.SetOwner(s.server)
 	s.commandServer = chanrpc.NewServer(s.ChanRPCLen, time.Duration(s.TimeoutAsynRet))
+	event.Register(s.server)
 }
 
 // Run start running all module.
@@ -51,40 +70,119 @@ func (s *Skeleton) Run(closeSig chan bool) {
 	for {
 		select {
 		case <-closeSig:
-			s.commandServer.Close()
-			s.server.Close()
-			s.g.Close()
-			s.client.Close()
+			s.drain()
 			return
 		case ri := <-s.client.ChanAsynRet:
-			s.client.Cb(ri)
+			s.loop(func() { s.cb(ri) })
+		case ci := <-s.server.ChanSystem:
+			s.server.Serve(ci)
 		case ci := <-s.server.ChanCall:
-			s.server.Exec(ci)
+			s.server.Serve(ci)
+		case ci := <-s.server.ChanBulk:
+			s.server.Serve(ci)
 		case ci := <-s.commandServer.ChanCall:
-			s.commandServer.Exec(ci)
+			s.loop(func() { s.commandServer.Exec(ci) })
 		case cb := <-s.g.ChanCb:
-			s.g.Cb(cb)
+			highWater(&s.maxCb, len(s.g.ChanCb)+1)
+			s.loop(func() { s.g.Cb(cb) })
 		case t := <-s.dispatcher.ChanTimer:
-			t.Cb()
+			highWater(&s.maxTimer, len(s.dispatcher.ChanTimer)+1)
+			s.loop(t.Cb)
 		}
 	}
 }
 
+// Step handle one call, return of async call, callback of Go or timer if any is ready, without blocking. It return false
+// if none is ready. It is used to drive s in a test instead of Run.
+func (s *Skeleton) Step() bool {
+	select {
+	case ri := <-s.client.ChanAsynRet:
+		s.loop(func() { s.cb(ri) })
+	case ci := <-s.server.ChanSystem:
+		s.server.Serve(ci)
+	case ci := <-s.server.ChanCall:
+		s.server.Serve(ci)
+	case ci := <-s.server.ChanBulk:
+		s.server.Serve(ci)
+	case ci := <-s.commandServer.ChanCall:
+		s.loop(func() { s.commandServer.Exec(ci) })
+	case cb := <-s.g.ChanCb:
+		highWater(&s.maxCb, len(s.g.ChanCb)+1)
+		s.loop(func() { s.g.Cb(cb) })
+	case t := <-s.dispatcher.ChanTimer:
+		highWater(&s.maxTimer, len(s.dispatcher.ChanTimer)+1)
+		s.loop(t.Cb)
+	default:
+		return false
+	}
+	return true
+}
+
+// loop run f as work of the loop of s, sync calls made by f block s, see chanrpc.Client.SetOwner.
+func (s *Skeleton) loop(f func()) {
+	s.server.Enter()
+	defer s.server.Leave()
+	f()
+}
+
+// cb pass async return ri to its callback.
+func (s *Skeleton) cb(ri *chanrpc.RetInfo) {
+	if s.recorder != nil {
+		s.recorder.asynRet(ri)
+	}
+	s.client.Cb(ri)
+}
+
 // AfterFunc call cb after d duration.
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
+	if s.replayer != nil {
+		return s.replayer.afterFunc(s.nextTask(), cb)
+	}
+	if s.recorder != nil {
+		cb = s.recorder.task(JournalTimer, s.nextTask(), cb)
+	}
 	return s.dispatcher.AfterFunc(d, cb)
 }
 
+// SetClock set the clock of timers and crons created later, the default is timer.SystemClock.
+func (s *Skeleton) SetClock(c timer.Clock) {
+	s.dispatcher.SetClock(c)
+}
+
+// Now return the current time of the clock of timers.
+func (s *Skeleton) Now() time.Time {
+	return s.dispatcher.Now()
+}
+
 // CronFunc do a cron job on time dispatcher.
 func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
+	if s.replayer != nil {
+		return s.replayer.cronFunc(s.nextTask(), cb)
+	}
+	if s.recorder != nil {
+		cb = s.recorder.task(JournalTimer, s.nextTask(), cb)
+	}
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
 // Go do call to go module.
 func (s *Skeleton) Go(f func(), cb func()) {
+	if s.replayer != nil {
+		s.replayer.goFunc(s.nextTask(), f, cb)
+		return
+	}
+	if s.recorder != nil {
+		cb = s.recorder.task(JournalGo, s.nextTask(), cb)
+	}
 	s.g.Go(f, cb)
 }
 
+// nextTask return the sequence of a new timer, cron or Go.
+func (s *Skeleton) nextTask() uint64 {
+	s.task++
+	return s.task
+}
+
 // NewLinearContext create new linear context.
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
@@ -105,22 +203,94 @@ func (s *Skeleton) SynCall(server *chanrpc.Server, id interface{}, args ...inter
 	return s.client.SynCall(server, id, args...)
 }
 
+// AsynCallContext do a async call to rpc server, the callback get the error of ctx if ctx is done before return.
+func (s *Skeleton) AsynCallContext(ctx context.Context, server *chanrpc.Server, id interface{}, args ...interface{}) error {
+	return s.client.AsynCallContext(ctx, server, id, args...)
+}
+
+// SynCallContext do a syn call to rpc server, return the error of ctx if ctx is done before return.
+func (s *Skeleton) SynCallContext(ctx context.Context, server *chanrpc.Server, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.client.SynCallContext(ctx, server, id, args...)
+}
+
+// AsynCallFuture do a async call to rpc server, and return a future of its return resolved in the goroutine of Run.
+func (s *Skeleton) AsynCallFuture(server *chanrpc.Server, id interface{}, args ...interface{}) *chanrpc.Future {
+	return s.client.AsynCallFuture(server, id, args...)
+}
+
+// Stream do a streaming call to rpc server, onItem and onDone are called in the goroutine of Run.
+func (s *Skeleton) Stream(server *chanrpc.Server, id interface{}, onItem func(interface{}), onDone func(error), args ...interface{}) (cancel func(), err error) {
+	return s.client.Stream(server, id, onItem, onDone, args...)
+}
+
+// Gather do the same call to every rpc server, and return a future of []chanrpc.GatherResult resolved in the goroutine
+// of Run, ctx is the shared deadline.
+func (s *Skeleton) Gather(ctx context.Context, servers []*chanrpc.Server, id interface{}, args ...interface{}) *chanrpc.Future {
+	return s.client.Gather(ctx, servers, id, args...)
+}
+
 // GoRpc do a async call to this Skeleton's rpc server, but no async return.
 func (s *Skeleton) GoRpc(id interface{}, args ...interface{}) {
 	s.AsynCall(s.server, id, args...)
 }
 
+// Subscribe make f called in the goroutine of Run with every event published to topics matching pattern in Bus. It is
+// unsubscribed when Run stops.
+func (s *Skeleton) Subscribe(pattern string, f func(topic string, event interface{})) *event.Subscription {
+	sub := s.Bus.Subscribe(s.server, pattern, f)
+	s.subs = append(s.subs, sub)
+	return sub
+}
+
+// Publish publish event to topic in Bus, it return the number of subscribers accepting it.
+func (s *Skeleton) Publish(topic string, event interface{}) int {
+	return s.Bus.Publish(topic, event)
+}
+
+// SetChanRPCPriority set the priority of handler id, calls of higher priority are handled first.
+func (s *Skeleton) SetChanRPCPriority(id interface{}, p chanrpc.Priority) {
+	s.server.SetPriority(id, p)
+}
+
 // GetChanrpcServer return this Skeleton's rpc server
 func (s *Skeleton) GetChanrpcServer() *chanrpc.Server {
 	return s.server
 }
 
+// GetChanrpcClient return this Skeleton's rpc client, its async return is handled in the goroutine of Run.
+func (s *Skeleton) GetChanrpcClient() *chanrpc.Client {
+	return s.client
+}
+
 // RegisterChanRPC register handler for id.
 func (s *Skeleton) RegisterChanRPC(id interface{}, f func([]interface{}) (interface{}, error)) {
 	s.server.Register(id, f)
 }
 
-// SetChanRPCHandle set rpc handlers.
+// RegisterChanRPCStream register streaming handler for id.
+func (s *Skeleton) RegisterChanRPCStream(id interface{}, f func([]interface{}, *chanrpc.Stream)) {
+	s.server.RegisterStream(id, f)
+}
+
+// UseChanRPC append interceptors which wrap every rpc handler of this Skeleton.
+func (s *Skeleton) UseChanRPC(interceptors ...chanrpc.Interceptor) {
+	s.server.Use(interceptors...)
+}
+
+// UseClient append interceptors which wrap every rpc call made by this Skeleton.
+func (s *Skeleton) UseClient(interceptors ...chanrpc.Interceptor) {
+	s.client.Use(interceptors...)
+}
+
+// SetChanRPCHandle set rpc handlers copied from m, the handler of event delivery is kept unless m has one. m is never
+// changed.
 func (s *Skeleton) SetChanRPCHandlers(m map[interface{}]interface{}) {
-	s.server.SetHandlers(m)
+	handlers := make(map[interface{}]interface{}, len(m)+1)
+	for id, f := range m {
+		handlers[id] = f
+	}
+	s.server.SetHandlers(handlers)
+	if _, ok := handlers[event.Id]; !ok {
+		event.Register(s.server)
+	}
 }
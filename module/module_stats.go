@@ -0,0 +1,55 @@
+package module
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"sync/atomic"
+)
+
+// QueueStats is the stats of a channel served by the loop of Skeleton.
+type QueueStats struct {
+	Depth    int // items waiting in channel.
+	MaxDepth int // high-water mark of Depth, observed when items are served.
+	Cap      int // capacity of channel.
+}
+
+// SkeletonStats is a snapshot of stats of Skeleton.
+type SkeletonStats struct {
+	Server  chanrpc.ServerStats // stats of rpc server.
+	Command chanrpc.ServerStats // stats of command server.
+	Client  chanrpc.ClientStats // stats of rpc client, its depth is of ChanAsynRet.
+	Go      QueueStats          // stats of ChanCb of Go.
+	Timer   QueueStats          // stats of ChanTimer of timer dispatcher.
+	Pending int                 // calls of Go not called back yet.
+	Timers  int                 // timers not fired or stopped yet.
+}
+
+// Stats return the stats of s, it is goroutine safe.
+func (s *Skeleton) Stats() SkeletonStats {
+	return SkeletonStats{
+		Server:  s.server.Stats(),
+		Command: s.commandServer.Stats(),
+		Client:  s.client.Stats(),
+		Go: QueueStats{
+			Depth:    len(s.g.ChanCb),
+			MaxDepth: int(atomic.LoadInt64(&s.maxCb)),
+			Cap:      cap(s.g.ChanCb),
+		},
+		Timer: QueueStats{
+			Depth:    len(s.dispatcher.ChanTimer),
+			MaxDepth: int(atomic.LoadInt64(&s.maxTimer)),
+			Cap:      cap(s.dispatcher.ChanTimer),
+		},
+		Pending: s.g.Pending(),
+		Timers:  s.dispatcher.Active(),
+	}
+}
+
+// highWater raise the high-water mark max to depth.
+func highWater(max *int64, depth int) {
+	for {
+		old := atomic.LoadInt64(max)
+		if int64(depth) <= old || atomic.CompareAndSwapInt64(max, old, int64(depth)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/module"
+	"testing"
+	"time"
+)
+
+func TestSkeletonStats(t *testing.T) {
+	s := &module.Skeleton{ChanRPCLen: 10, GoLen: 10, TimerDispatcherLen: 10}
+	s.Init()
+	s.RegisterChanRPC("go", func(args []interface{}) (interface{}, error) {
+		for i := 0; i < 3; i++ {
+			s.Go(func() {}, nil)
+		}
+		s.AfterFunc(time.Millisecond, func() {})
+		// the loop is blocked by the handler, so callbacks of Go pile up.
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		s.Run(closeSig)
+		done <- true
+	}()
+
+	c := chanrpc.NewClient(10, time.Second)
+	c.SynCall(s.GetChanrpcServer(), "go")
+	time.Sleep(10 * time.Millisecond)
+	closeSig <- true
+	<-done
+
+	st := s.Stats()
+	if st.Server.Handlers["go"].Calls != 1 {
+		t.Errorf("unexpected stats of server %+v", st.Server)
+	}
+	if st.Go.MaxDepth != 3 || st.Go.Cap != 10 || st.Timer.MaxDepth != 1 {
+		t.Errorf("unexpected stats of queues %+v %+v", st.Go, st.Timer)
+	}
+}
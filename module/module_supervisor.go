@@ -0,0 +1,217 @@
+package module
+
+import (
+	"fmt"
+	"github.com/LuisZhou/lpge/conf"
+	"github.com/LuisZhou/lpge/event"
+	"github.com/LuisZhou/lpge/log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Strategy decide what to restart when Run of module panics.
+type Strategy int
+
+const (
+	Temporary Strategy = iota // never restart, the module stops, the default strategy.
+	OneForOne                 // restart the crashed module.
+	OneForAll                 // restart the crashed module, and all other running modules of OneForAll.
+)
+
+// RestartPolicy is the policy of restarting module whose Run panics. A restart calls OnDestroy and OnInit, and then Run
+// again. The crashed module is not closed, so a Skeleton keeps its calls waiting, while other modules restarted by
+// OneForAll are closed like Destroy, their OnInit must set them up again.
+type RestartPolicy struct {
+	Strategy    Strategy      // what to restart.
+	MaxRestarts int           // max restarts within Window, the module stops once it crashes more, 0 is no limit.
+	Window      time.Duration // window of counting restarts, 0 counts all restarts.
+	Backoff     time.Duration // delay of the first restart within Window, doubled by each following one.
+	MaxBackoff  time.Duration // max delay of restart, 0 is no limit.
+}
+
+// Supervised is implemented by Module having its own restart policy, such as modules registered by RegisterAll.
+type Supervised interface {
+	RestartPolicy() RestartPolicy // restart policy of module.
+}
+
+// topics of lifecycle events of module, published to event.Default.
+const (
+	TopicStarted   = "module.started"   // module is registered and running.
+	TopicCrashed   = "module.crashed"   // Run of module panicked.
+	TopicRestarted = "module.restarted" // module is restarted.
+	TopicGaveUp    = "module.gaveup"    // module crashed too many times, and stopped.
+	TopicStopped   = "module.stopped"   // module is destroyed.
+)
+
+// Lifecycle is the event of lifecycle of module.
+type Lifecycle struct {
+	Name     string      // name of module.
+	Reason   interface{} // value of panic, nil if not crashed.
+	Restarts int         // restarts within the window of policy.
+}
+
+// publish publish lifecycle event of m to topic.
+func publish(topic string, m *module, reason interface{}) {
+	m.mutexStatus.Lock()
+	restarts := len(m.restarts)
+	m.mutexStatus.Unlock()
+	event.Publish(topic, &Lifecycle{Name: m.name, Reason: reason, Restarts: restarts})
+}
+
+// run run module, and restart it by its policy when it crashes.
+func run(m *module) {
+	defer m.wg.Done()
+
+	for {
+		reason := runSafe(m)
+		if reason == nil {
+			return
+		}
+		publish(TopicCrashed, m, reason)
+
+		delay, ok := m.nextRestart()
+		if !ok {
+			log.Error("module %v gave up restarting", m.name)
+			m.setStatus(StatusFailed)
+			publish(TopicGaveUp, m, reason)
+			return
+		}
+		m.setStatus(StatusRestarting)
+
+		select {
+		case <-m.closeSig:
+			return
+		case <-time.After(delay):
+		}
+
+		destroy(m)
+		if err := catch(m.init); err != nil {
+			log.Error("module %v failed to init: %v", m.name, err)
+			m.setStatus(StatusFailed)
+			publish(TopicGaveUp, m, err)
+			return
+		}
+		m.countRestart()
+		m.setStatus(StatusRunning)
+		publish(TopicRestarted, m, reason)
+
+		if m.policy.Strategy == OneForAll {
+			go restartAll(m)
+		}
+	}
+}
+
+// runSafe call Run of m, and return the value of panic, nil if Run returns.
+func runSafe(m *module) (reason interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			reason = r
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Error("module %v crashed: %v: %s", m.name, r, buf[:l])
+			} else {
+				log.Error("module %v crashed: %v", m.name, r)
+			}
+		}
+	}()
+
+	m.Module.Run(m.closeSig)
+	return nil
+}
+
+// nextRestart count a restart, and return the delay of it, or false if the module should stop.
+func (m *module) nextRestart() (time.Duration, bool) {
+	p := m.policy
+	if p.Strategy == Temporary {
+		return 0, false
+	}
+
+	m.mutexStatus.Lock()
+	defer m.mutexStatus.Unlock()
+
+	now := time.Now()
+	if p.Window > 0 {
+		i := 0
+		for i < len(m.restarts) && now.Sub(m.restarts[i]) > p.Window {
+			i++
+		}
+		m.restarts = m.restarts[i:]
+	}
+	if p.MaxRestarts > 0 && len(m.restarts) >= p.MaxRestarts {
+		return 0, false
+	}
+
+	delay := p.Backoff
+	for i := 0; i < len(m.restarts) && delay > 0 && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	m.restarts = append(m.restarts, now)
+	return delay, true
+}
+
+// restarting serialize restartAll.
+var restarting sync.Mutex
+
+// restartAll restart other modules of OneForAll than m, except those failed, they are stopped in the reverse order of registration, and
+// started in the order of registration. It runs in its own goroutine, so modules restarting each other never wait for
+// each other.
+func restartAll(m *module) {
+	restarting.Lock()
+	defer restarting.Unlock()
+
+	mutex.Lock()
+	var peers []*module
+	for _, name := range order {
+		if p := names[name]; p != m && p.policy.Strategy == OneForAll && !p.failed() {
+			peers = append(peers, p)
+		}
+	}
+	mutex.Unlock()
+
+	for i := len(peers) - 1; i >= 0; i-- {
+		p := peers[i]
+		p.lock.Lock()
+		// a signal may be pending already, such as the one of a stop timed out.
+		select {
+		case p.closeSig <- true:
+		default:
+		}
+		p.wg.Wait()
+		// the module stopped already, the signal is left.
+		select {
+		case <-p.closeSig:
+		default:
+		}
+		destroy(p)
+	}
+
+	for _, p := range peers {
+		if err := catch(p.init); err != nil {
+			log.Error("module %v failed to init: %v", p.name, err)
+			p.setStatus(StatusFailed)
+		} else {
+			p.countRestart()
+			p.start()
+			publish(TopicRestarted, p, nil)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// catch call f, and return the panic of it as error.
+func catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	f()
+	return nil
+}
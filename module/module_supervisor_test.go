@@ -0,0 +1,174 @@
+package module_test
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/event"
+	"github.com/LuisZhou/lpge/module"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// crashy is a module whose Run panics on demand.
+type crashy struct {
+	*module.Skeleton
+	crash chan bool
+	inits int32
+}
+
+func newCrashy() *crashy {
+	s := &module.Skeleton{}
+	s.Init()
+	return &crashy{Skeleton: s, crash: make(chan bool, 1)}
+}
+
+func (m *crashy) OnInit() {
+	atomic.AddInt32(&m.inits, 1)
+}
+
+func (m *crashy) OnDestroy() {
+}
+
+func (m *crashy) Run(closeSig chan bool) {
+	select {
+	case <-closeSig:
+	case <-m.crash:
+		panic("crash")
+	}
+}
+
+// lifecycle return a channel of lifecycle events of modules, and the function to stop it.
+func lifecycle(names ...string) (chan string, func()) {
+	s := chanrpc.NewServer(100, 0)
+	event.Register(s)
+	ch := make(chan string, 100)
+	sub := event.Subscribe(s, "module.*", func(topic string, e interface{}) {
+		for _, name := range names {
+			if e.(*module.Lifecycle).Name == name {
+				ch <- name + " " + topic
+			}
+		}
+	})
+
+	closeSig := make(chan bool)
+	go func() {
+		for {
+			select {
+			case ci := <-s.ChanCall:
+				s.Exec(ci)
+			case <-closeSig:
+				return
+			}
+		}
+	}()
+	return ch, func() {
+		sub.Unsubscribe()
+		close(closeSig)
+	}
+}
+
+func expect(t *testing.T, ch chan string, events ...string) {
+	for _, e := range events {
+		select {
+		case got := <-ch:
+			if got != e {
+				t.Fatalf("expect %v, got %v", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %v, got nothing", e)
+		}
+	}
+}
+
+func TestOneForOne(t *testing.T) {
+	ch, stop := lifecycle("sup-one")
+	defer stop()
+
+	m := newCrashy()
+	module.Register(m, "sup-one", module.RestartPolicy{
+		Strategy:    module.OneForOne,
+		MaxRestarts: 2,
+		Window:      time.Minute,
+		Backoff:     10 * time.Millisecond,
+	})
+	expect(t, ch, "sup-one module.started")
+
+	start := time.Now()
+	m.crash <- true
+	expect(t, ch, "sup-one module.crashed", "sup-one module.restarted")
+	m.crash <- true
+	expect(t, ch, "sup-one module.crashed", "sup-one module.restarted")
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("expect backoff, restarted in %v", d)
+	}
+
+	m.crash <- true
+	expect(t, ch, "sup-one module.crashed", "sup-one module.gaveup")
+	if n := atomic.LoadInt32(&m.inits); n != 3 {
+		t.Errorf("expect 3 inits, got %v", n)
+	}
+
+	module.DestroyOne("sup-one")
+	expect(t, ch, "sup-one module.stopped")
+}
+
+func TestTemporary(t *testing.T) {
+	ch, stop := lifecycle("sup-temp")
+	defer stop()
+
+	m := newCrashy()
+	module.Register(m, "sup-temp")
+	m.crash <- true
+	expect(t, ch, "sup-temp module.started", "sup-temp module.crashed", "sup-temp module.gaveup")
+	module.DestroyOne("sup-temp")
+}
+
+func TestOneForAll(t *testing.T) {
+	ch, stop := lifecycle("sup-all-a", "sup-all-b")
+	defer stop()
+
+	policy := module.RestartPolicy{Strategy: module.OneForAll}
+	a, b := newCrashy(), newCrashy()
+	module.Register(a, "sup-all-a", policy)
+	module.Register(b, "sup-all-b", policy)
+	expect(t, ch, "sup-all-a module.started", "sup-all-b module.started")
+
+	a.crash <- true
+	expect(t, ch, "sup-all-a module.crashed", "sup-all-a module.restarted", "sup-all-b module.restarted")
+	if atomic.LoadInt32(&a.inits) != 2 || atomic.LoadInt32(&b.inits) != 2 {
+		t.Error("expect both modules restarted")
+	}
+
+	module.DestroyOne("sup-all-b")
+	module.DestroyOne("sup-all-a")
+}
+
+func TestOneForAllFailed(t *testing.T) {
+	ch, stop := lifecycle("sup-failed-a", "sup-failed-b")
+	defer stop()
+
+	a, b := newCrashy(), newCrashy()
+	module.Register(a, "sup-failed-a", module.RestartPolicy{Strategy: module.OneForAll})
+	module.Register(b, "sup-failed-b", module.RestartPolicy{Strategy: module.OneForAll, MaxRestarts: 1})
+	expect(t, ch, "sup-failed-a module.started", "sup-failed-b module.started")
+
+	b.crash <- true
+	expect(t, ch, "sup-failed-b module.crashed", "sup-failed-b module.restarted", "sup-failed-a module.restarted")
+	b.crash <- true
+	expect(t, ch, "sup-failed-b module.crashed", "sup-failed-b module.gaveup")
+
+	// b failed, it is left as it is.
+	a.crash <- true
+	expect(t, ch, "sup-failed-a module.crashed", "sup-failed-a module.restarted")
+	select {
+	case e := <-ch:
+		t.Errorf("unexpected %v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if info, _ := module.Inspect("sup-failed-b"); info.Status != module.StatusFailed || atomic.LoadInt32(&b.inits) != 2 {
+		t.Errorf("expect b failed and not restarted, got %v, %v inits", info.Status, atomic.LoadInt32(&b.inits))
+	}
+
+	module.DestroyOne("sup-failed-b")
+	module.DestroyOne("sup-failed-a")
+}
@@ -0,0 +1,143 @@
+// Package moduletest provide a harness testing module built on Skeleton, on a virtual clock and a loop stepped by test,
+// so timers and crons fire only when the test advances time.
+package moduletest
+
+import (
+	"github.com/LuisZhou/lpge/chanrpc"
+	"github.com/LuisZhou/lpge/module"
+	"github.com/LuisZhou/lpge/timer"
+	"testing"
+	"time"
+)
+
+// Start is the time the virtual clock of harness starts at.
+var Start = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Harness drive a Skeleton in the goroutine of test instead of Run. Functions of Go still run in their own goroutines,
+// the harness waits for their callbacks, and for returns of async calls made by the Skeleton.
+type Harness struct {
+	T     testing.TB          // test failed by harness.
+	Clock *timer.VirtualClock // clock of timers of Skeleton.
+	Wait  time.Duration       // max real time waiting for callbacks of Go and returns of async calls.
+	s     *module.Skeleton    // Skeleton driven.
+	c     *chanrpc.Client     // client injecting calls.
+}
+
+// New create harness of s, which must be initialized and not running. Timers and crons of s created before are left on
+// the system clock.
+func New(t testing.TB, s *module.Skeleton) *Harness {
+	h := &Harness{
+		T:     t,
+		Clock: timer.NewVirtualClock(Start),
+		Wait:  time.Second,
+		s:     s,
+		c:     chanrpc.NewClient(1, 0),
+	}
+	s.SetClock(h.Clock)
+	return h
+}
+
+// Now return the current virtual time.
+func (h *Harness) Now() time.Time {
+	return h.Clock.Now()
+}
+
+// Settle handle everything ready, timers due now, callbacks of Go and returns of async calls, until nothing is left.
+// The test fails if callbacks or returns are waited longer than Wait.
+func (h *Harness) Settle() {
+	h.T.Helper()
+
+	deadline := time.Now().Add(h.Wait)
+	for {
+		for h.s.Step() {
+		}
+		if h.Clock.Step(h.Clock.Now()) {
+			continue
+		}
+
+		st := h.s.Stats()
+		if st.Pending == 0 && st.Client.Pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.T.Fatalf("%v callbacks of Go and %v returns of async calls not done in %v", st.Pending,
+				st.Client.Pending, h.Wait)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// AdvanceTo move the virtual clock to t, firing timers and crons due in order, each settled before the next one.
+func (h *Harness) AdvanceTo(t time.Time) {
+	h.T.Helper()
+
+	h.Settle()
+	for h.Clock.Step(t) {
+		h.Settle()
+	}
+	h.Clock.AdvanceTo(t)
+}
+
+// Advance move the virtual clock by d, firing timers and crons due in order.
+func (h *Harness) Advance(d time.Duration) {
+	h.T.Helper()
+	h.AdvanceTo(h.Clock.Now().Add(d))
+}
+
+// Next move the virtual clock to the earliest timer and fire timers due then, such as the next run of cron. It return
+// false if there is no timer.
+func (h *Harness) Next() bool {
+	h.T.Helper()
+
+	h.Settle()
+	when, ok := h.Clock.Next()
+	if !ok {
+		return false
+	}
+	h.AdvanceTo(when)
+	return true
+}
+
+// Call inject a call to the rpc server of Skeleton, settle it, and return its return. The test fails if the call is not
+// returned when settled.
+func (h *Harness) Call(id interface{}, args ...interface{}) (interface{}, error) {
+	h.T.Helper()
+
+	var (
+		ret interface{}
+		err error
+	)
+	args = append(args, func(r interface{}, e error) {
+		ret, err = r, e
+	})
+	if e := h.c.AsynCall(h.s.GetChanrpcServer(), id, args...); e != nil {
+		return nil, e
+	}
+
+	h.Settle()
+	select {
+	case ri := <-h.c.ChanAsynRet:
+		h.c.Cb(ri)
+	default:
+		h.T.Fatalf("call %v not returned", id)
+	}
+	return ret, err
+}
+
+// MustCall is like Call, but the test fails if the call return error.
+func (h *Harness) MustCall(id interface{}, args ...interface{}) interface{} {
+	h.T.Helper()
+
+	ret, err := h.Call(id, args...)
+	if err != nil {
+		h.T.Fatalf("call %v: %v", id, err)
+	}
+	return ret
+}
+
+// Close stop Skeleton like Run does when closed, its work left is drained.
+func (h *Harness) Close() {
+	closeSig := make(chan bool, 1)
+	closeSig <- true
+	h.s.Run(closeSig)
+}
@@ -0,0 +1,85 @@
+package moduletest_test
+
+import (
+	"github.com/LuisZhou/lpge/module"
+	"github.com/LuisZhou/lpge/module/moduletest"
+	"github.com/LuisZhou/lpge/timer"
+	"testing"
+	"time"
+)
+
+func TestHarness(t *testing.T) {
+	s := &module.Skeleton{ChanRPCLen: 10, GoLen: 10, TimerDispatcherLen: 10}
+	s.Init()
+	h := moduletest.New(t, s)
+	defer h.Close()
+
+	var fired []string
+	s.RegisterChanRPC("later", func(args []interface{}) (interface{}, error) {
+		s.AfterFunc(args[0].(time.Duration), func() {
+			fired = append(fired, args[1].(string))
+		})
+		return nil, nil
+	})
+	s.RegisterChanRPC("go", func(args []interface{}) (interface{}, error) {
+		var v string
+		s.Go(func() {
+			v = "go"
+		}, func() {
+			fired = append(fired, v)
+		})
+		return nil, nil
+	})
+	s.RegisterChanRPC("fired", func(args []interface{}) (interface{}, error) {
+		return len(fired), nil
+	})
+
+	h.MustCall("later", time.Minute, "b")
+	h.MustCall("later", time.Second, "a")
+	h.MustCall("go")
+	if len(fired) != 1 || fired[0] != "go" {
+		t.Fatalf("expect callback of Go settled, got %v", fired)
+	}
+
+	h.Advance(59 * time.Second)
+	if len(fired) != 2 || fired[1] != "a" {
+		t.Fatalf("expect timer a fired, got %v", fired)
+	}
+	h.Advance(time.Second)
+	if ret := h.MustCall("fired"); ret != 3 || fired[2] != "b" {
+		t.Fatalf("expect timer b fired, got %v", fired)
+	}
+	if !h.Now().Equal(moduletest.Start.Add(time.Minute)) {
+		t.Errorf("unexpected time %v", h.Now())
+	}
+	if _, err := h.Call("none"); err == nil {
+		t.Error("expect error of unknown call")
+	}
+}
+
+func TestHarnessCron(t *testing.T) {
+	s := &module.Skeleton{TimerDispatcherLen: 10}
+	s.Init()
+	h := moduletest.New(t, s)
+	defer h.Close()
+
+	cronExpr, err := timer.NewCronExpr("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []time.Time
+	s.CronFunc(cronExpr, func() {
+		runs = append(runs, s.Now())
+	})
+
+	for i := 0; i < 3; i++ {
+		if !h.Next() {
+			t.Fatal("expect next run of cron")
+		}
+	}
+	for i, r := range runs {
+		if !r.Equal(moduletest.Start.Add(time.Duration(i+1) * time.Hour)) {
+			t.Errorf("unexpected run %v at %v", i, r)
+		}
+	}
+}
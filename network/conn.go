@@ -17,3 +17,13 @@ type Conn interface {
 	// Close closes the conn and do clean up.
 	Close()
 }
+
+// FrameWriter is a Conn writing packed frames, so a message written to many conns of the same framing is packed once.
+type FrameWriter interface {
+	// Framing return a comparable value, conns of equal framing pack messages to the same frame.
+	Framing() interface{}
+	// Pack pack cmd and data to frame.
+	Pack(cmd uint16, data []byte) ([]byte, error)
+	// WriteFrame write frame packed by Pack, which may be shared by conns.
+	WriteFrame(frame []byte) error
+}
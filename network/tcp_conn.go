@@ -5,6 +5,7 @@ import (
 	"github.com/LuisZhou/lpge/log"
 	"net"
 	"sync"
+	"time"
 )
 
 // TCPConn repsent one session of TCP, giving the ablility of RW (msg) for agent.
@@ -96,12 +97,33 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 	return tcpConn.conn.RemoteAddr()
 }
 
+// SetReadDeadline set the deadline of reading, reading after it fails with timeout error.
+func (tcpConn *TCPConn) SetReadDeadline(t time.Time) error {
+	return tcpConn.conn.SetReadDeadline(t)
+}
+
 // ReadMsg is the api for reading msg from the connection.
 func (tcpConn *TCPConn) ReadMsg() (uint16, []byte, error) {
 	// msgParser read from io.Reader
 	return tcpConn.msgParser.Read(tcpConn)
 }
 
+// Framing return the msg parser, conns of the same server share frames.
+func (tcpConn *TCPConn) Framing() interface{} {
+	return tcpConn.msgParser
+}
+
+// Pack pack cmd and data to frame.
+func (tcpConn *TCPConn) Pack(cmd uint16, data []byte) ([]byte, error) {
+	return tcpConn.msgParser.Pack(cmd, data)
+}
+
+// WriteFrame write frame packed by Pack to the connection, the frame should not be modified after.
+func (tcpConn *TCPConn) WriteFrame(frame []byte) error {
+	_, err := tcpConn.Write(frame)
+	return err
+}
+
 // Write is the api for writing msg to the connection.
 func (tcpConn *TCPConn) WriteMsg(cmd uint16, data []byte) error {
 	// msgParse Write cmd, data to io.Writer
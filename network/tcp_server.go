@@ -123,6 +123,12 @@ func (server *TCPServer) run() {
 	}
 }
 
+// Stop shut down the listener of tcp, so no new connection is accepted, while TCPConns connected are kept until Close.
+func (server *TCPServer) Stop() {
+	server.ln.Close()
+	server.wgLn.Wait()
+}
+
 // Close shut down the listener of tcp and clean up all TCPConn.
 func (server *TCPServer) Close() {
 	// shut down all the close conn, cause the goroutine of server to exist.
@@ -7,6 +7,7 @@ import (
 	"net"
 	_ "strconv"
 	"sync"
+	"time"
 )
 
 const WS_HEADER_SIZE uint32 = 2
@@ -89,6 +90,39 @@ func (wsConn *WSConn) RemoteAddr() net.Addr {
 	return wsConn.conn.RemoteAddr()
 }
 
+// SetReadDeadline set the deadline of reading, reading after it fails with timeout error.
+func (wsConn *WSConn) SetReadDeadline(t time.Time) error {
+	return wsConn.conn.SetReadDeadline(t)
+}
+
+// Ping send ping to peer every interval until the connection is closed. A pong of peer extends the read deadline by
+// timeout, if timeout is positive.
+func (wsConn *WSConn) Ping(interval time.Duration, timeout time.Duration) {
+	if timeout > 0 {
+		wsConn.conn.SetPongHandler(func(string) error {
+			return wsConn.conn.SetReadDeadline(time.Now().Add(timeout))
+		})
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			wsConn.Lock()
+			closed := wsConn.closeFlag
+			wsConn.Unlock()
+			if closed {
+				return
+			}
+			// WriteControl is safe with the write routine.
+			if err := wsConn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				log.Debug("ping error: %v", err)
+				return
+			}
+		}
+	}()
+}
+
 // ReadMsg is the api for reading msg from the connection.
 func (wsConn *WSConn) ReadMsg() (uint16, []byte, error) {
 	_, b, err := wsConn.conn.ReadMessage()
@@ -100,22 +134,46 @@ func (wsConn *WSConn) ReadMsg() (uint16, []byte, error) {
 	return cmd, b[WS_HEADER_SIZE:], err
 }
 
-// Write is the api for writing msg to the connection.
-func (wsConn *WSConn) WriteMsg(cmd uint16, data []byte) error {
-	wsConn.Lock()
-	defer wsConn.Unlock()
-	if wsConn.closeFlag {
-		return errors.New("ws connect has closed")
-	}
+// wsFraming is the framing of WSConn, conns of the same max msg len share frames.
+type wsFraming struct {
+	maxMsgLen uint32
+}
 
+// Framing return the framing of wsConn.
+func (wsConn *WSConn) Framing() interface{} {
+	return wsFraming{maxMsgLen: wsConn.maxMsgLen}
+}
+
+// Pack pack cmd and data to frame.
+func (wsConn *WSConn) Pack(cmd uint16, data []byte) ([]byte, error) {
 	var msgLen uint32 = uint32(len(data)) + WS_HEADER_SIZE
 	if msgLen > wsConn.maxMsgLen {
-		return errors.New("message too long")
+		return nil, errors.New("message too long")
 	} else if msgLen < WS_HEADER_SIZE {
-		return errors.New("message too short")
+		return nil, errors.New("message too short")
 	}
 
 	// use little endian. !!double_check!!
-	wsConn.doWrite(append([]byte{byte(cmd & 0xff), byte(cmd >> 8 & 0xff)}, data[:]...))
+	return append([]byte{byte(cmd & 0xff), byte(cmd >> 8 & 0xff)}, data[:]...), nil
+}
+
+// WriteFrame write frame packed by Pack to the connection, the frame should not be modified after.
+func (wsConn *WSConn) WriteFrame(frame []byte) error {
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		return errors.New("ws connect has closed")
+	}
+
+	wsConn.doWrite(frame)
 	return nil
 }
+
+// Write is the api for writing msg to the connection.
+func (wsConn *WSConn) WriteMsg(cmd uint16, data []byte) error {
+	frame, err := wsConn.Pack(cmd, data)
+	if err != nil {
+		return err
+	}
+	return wsConn.WriteFrame(frame)
+}
@@ -149,6 +149,12 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+// Stop shut down the listener of websocket, so no new connection is accepted, while WSConns connected are kept until
+// Close.
+func (server *WSServer) Stop() {
+	server.ln.Close()
+}
+
 func (server *WSServer) Close() {
 	server.ln.Close()
 
@@ -0,0 +1,153 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of Dispatcher.
+type Clock interface {
+	Now() time.Time                                         // current time.
+	AfterFunc(d time.Duration, f func()) (stop func() bool) // call f after d, stop return false if f is called or stopped already.
+}
+
+// SystemClock is the clock of package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// VirtualClock is a clock whose time only moves when it is advanced, timers are fired by the goroutine advancing it, so
+// time of test is deterministic. It is goroutine safe.
+type VirtualClock struct {
+	now    time.Time
+	seq    uint64
+	timers virtualTimers
+	mutex  sync.Mutex
+}
+
+// virtualTimer is a timer of VirtualClock.
+type virtualTimer struct {
+	when  time.Time
+	seq   uint64 // timers of the same time fire in the order of creation.
+	f     func()
+	index int // index in heap, -1 if fired or stopped.
+}
+
+// virtualTimers is a heap of timers, the earliest first.
+type virtualTimers []*virtualTimer
+
+func (h virtualTimers) Len() int {
+	return len(h)
+}
+
+func (h virtualTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h virtualTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *virtualTimers) Push(x interface{}) {
+	t := x.(*virtualTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *virtualTimers) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
+
+// NewVirtualClock create a virtual clock starting at now.
+func NewVirtualClock(now time.Time) *VirtualClock {
+	return &VirtualClock{now: now}
+}
+
+// Now return the current virtual time.
+func (c *VirtualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// AfterFunc call f when the clock is advanced by d, f is called by the goroutine advancing the clock.
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.seq++
+	t := &virtualTimer{when: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+	return func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if t.index < 0 {
+			return false
+		}
+		heap.Remove(&c.timers, t.index)
+		return true
+	}
+}
+
+// Next return the time of the earliest timer, false if there is no timer.
+func (c *VirtualClock) Next() (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// Step fire the earliest timer not later than until, and move the clock to its time if it is later than now. It return
+// false if there is no such timer.
+func (c *VirtualClock) Step(until time.Time) bool {
+	c.mutex.Lock()
+	if len(c.timers) == 0 || c.timers[0].when.After(until) {
+		c.mutex.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*virtualTimer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.mutex.Unlock()
+
+	t.f()
+	return true
+}
+
+// AdvanceTo fire timers not later than t in order, and move the clock to t if it is later than now.
+func (c *VirtualClock) AdvanceTo(t time.Time) {
+	for c.Step(t) {
+	}
+
+	c.mutex.Lock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.mutex.Unlock()
+}
+
+// Advance fire timers within d in order, and move the clock by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
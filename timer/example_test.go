@@ -69,3 +69,27 @@ func ExampleCron() {
 	// Here is lpge
 	// Here is lpge
 }
+
+func ExampleVirtualClock() {
+	d := timer.NewDispatcher(10)
+	c := timer.NewVirtualClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	d.SetClock(c)
+
+	d.AfterFunc(2*time.Second, func() {
+		fmt.Println("timer 2")
+	})
+	d.AfterFunc(time.Second, func() {
+		fmt.Println("timer 1")
+	})
+
+	// timers fire only when the clock is advanced
+	c.Advance(3 * time.Second)
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+	fmt.Println(c.Now())
+
+	// Output:
+	// timer 1
+	// timer 2
+	// 2000-01-01 00:00:03 +0000 UTC
+}
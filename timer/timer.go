@@ -6,18 +6,22 @@ import (
 	"github.com/LuisZhou/lpge/conf"
 	"github.com/LuisZhou/lpge/log"
 	"runtime"
+	"sync/atomic"
 	"time"
 )
 
 // Timer compose sys timer and cb.
 type Timer struct {
-	t  *time.Timer
-	cb func()
+	stop func() bool // stop of timer of clock.
+	cb   func()
+	disp *Dispatcher
 }
 
 // Stop can stop sys timer anytime.
 func (t *Timer) Stop() {
-	t.t.Stop()
+	if t.stop != nil && t.stop() {
+		atomic.AddInt64(&t.disp.active, -1)
+	}
 	t.cb = nil
 }
 
@@ -25,6 +29,9 @@ func (t *Timer) Stop() {
 func (t *Timer) Cb() {
 	defer func() {
 		t.cb = nil
+		if t.disp != nil {
+			atomic.AddInt64(&t.disp.active, -1)
+		}
 		if r := recover(); r != nil {
 			var err error
 			if conf.LenStackBuf > 0 {
@@ -46,25 +53,45 @@ func (t *Timer) Cb() {
 // Dispatcher make control of the timer, make the callback of the timer serialize execute in the FIFO.
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	active    int64 // number of timers not fired or stopped yet.
+	clock     Clock // clock of timers.
 }
 
 // Create a new time dispatch.
 func NewDispatcher(l int) *Dispatcher {
 	disp := new(Dispatcher)
 	disp.ChanTimer = make(chan *Timer, l)
+	disp.clock = SystemClock
 	return disp
 }
 
+// SetClock set the clock of timers created later, the default is SystemClock.
+func (disp *Dispatcher) SetClock(c Clock) {
+	disp.clock = c
+}
+
+// Now return the current time of clock.
+func (disp *Dispatcher) Now() time.Time {
+	return disp.clock.Now()
+}
+
 // AfterFunc push a Timer in channel after d.
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
-	t.t = time.AfterFunc(d, func() {
+	t.disp = disp
+	atomic.AddInt64(&disp.active, 1)
+	t.stop = disp.clock.AfterFunc(d, func() {
 		disp.ChanTimer <- t
 	})
 	return t
 }
 
+// Active return the number of timers not fired or stopped yet, it is goroutine safe.
+func (disp *Dispatcher) Active() int {
+	return int(atomic.LoadInt64(&disp.active))
+}
+
 // Cron schedule AfterFunc-job.
 type Cron struct {
 	t *Timer
@@ -81,7 +108,7 @@ func (c *Cron) Stop() {
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := disp.clock.Now()
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -92,7 +119,7 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
+		now := disp.clock.Now()
 		nextTime := cronExpr.Next(now)
 		if nextTime.IsZero() {
 			return